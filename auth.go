@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/google/go-github/v33/github"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
@@ -15,6 +16,8 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sync"
 )
 
 func GithubAuthenticate() (*github.Client, context.Context) {
@@ -28,18 +31,26 @@ func GithubAuthenticate() (*github.Client, context.Context) {
 	return gc, ctx
 }
 
-func K8sAuthenticate() *kubernetes.Clientset {
+func K8sAuthenticate() (*kubernetes.Clientset, error) {
 	// create k8s client
 	cfg, err := clientcmd.BuildConfigFromFlags("", os.Getenv("OPENSHIFT_KUBECONFIG"))
-	ErrorCheck("The kubeconfig could not be loaded", err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig: %w", err)
+	}
+
 	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create k8s client: %w", err)
+	}
 
-	return clientset
+	return clientset, nil
 }
 
 func DefaultClientK8sAuthenticate() (*rest.Config, error) {
 	cfg, err := clientcmd.LoadFromFile(os.Getenv("OPENSHIFT_KUBECONFIG"))
-	ErrorCheck("The kubeconfig could not be loaded", err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig: %w", err)
+	}
 	dc := clientcmd.NewDefaultClientConfig(*cfg, &clientcmd.ConfigOverrides{})
 
 	return dc.ClientConfig()
@@ -47,32 +58,133 @@ func DefaultClientK8sAuthenticate() (*rest.Config, error) {
 
 func DynamicClientK8sAuthenticate() (Interface, error) {
 	cfg, err := clientcmd.BuildConfigFromFlags("", os.Getenv("OPENSHIFT_KUBECONFIG"))
-	ErrorCheck("The kubeconfig could not be loaded", err)
-	dc, err := NewForConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load kubeconfig: %w", err)
+	}
 
-	return dc, err
+	return NewForConfig(cfg)
 }
 
-func GoogleDriveAuthenticate(credentials string, token string) (client *http.Client, err error) {
+// GoogleDriveAuthenticate returns an HTTP client authorized for Drive and Sheets. credentials is
+// either an OAuth client secret, in which case the user token is read from token and refreshed
+// tokens are written back to it, or a service account key, in which case token is ignored.
+func GoogleDriveAuthenticate(ctx context.Context, credentials string, token string) (client *http.Client, err error) {
+	ts, err := GoogleTokenSource(ctx, credentials, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return oauth2.NewClient(ctx, ts), nil
+}
+
+// GoogleTokenSource returns a token source for the Drive and Sheets scopes built from the
+// credentials file and, for OAuth client credentials, the token file.
+func GoogleTokenSource(ctx context.Context, credentials string, token string) (oauth2.TokenSource, error) {
+	scopes := []string{drive.DriveScope, drive.DriveFileScope, sheets.SpreadsheetsScope}
+
 	credentialsFileBytes, err := ioutil.ReadFile(credentials)
-	ErrorCheck("Unable to read credentials file: ", err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file: %w", err)
+	}
 
-	credentialsConfig, err := google.ConfigFromJSON(credentialsFileBytes,
-		drive.DriveScope, drive.DriveFileScope, sheets.DriveScope, sheets.SpreadsheetsScope)
-	ErrorCheck("Unable to create config from credentials file: ", err)
+	var credentialsType struct {
+		Type string `json:"type"`
+	}
+	if err = json.Unmarshal(credentialsFileBytes, &credentialsType); err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file: %w", err)
+	}
 
-	tokenFile, err := os.Open(token)
-	ErrorCheck("Unable to open token file: ", err)
-	defer func(tokenFile *os.File) {
-		err := tokenFile.Close()
-		ErrorCheck("Unable to close token file: ", err)
-	}(tokenFile)
+	if credentialsType.Type == "service_account" {
+		jwtConfig, err := google.JWTConfigFromJSON(credentialsFileBytes, scopes...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create config from service account file: %w", err)
+		}
+		return jwtConfig.TokenSource(ctx), nil
+	}
+
+	credentialsConfig, err := google.ConfigFromJSON(credentialsFileBytes, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create config from credentials file: %w", err)
+	}
+
+	tokenFileBytes, err := ioutil.ReadFile(token)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token file: %w", err)
+	}
 
 	tokenJSON := &oauth2.Token{}
-	err = json.NewDecoder(tokenFile).Decode(tokenJSON)
-	ErrorCheck("Unable to parse token file: ", err)
+	if err = json.Unmarshal(tokenFileBytes, tokenJSON); err != nil {
+		return nil, fmt.Errorf("unable to parse token file: %w", err)
+	}
+	if tokenJSON.AccessToken == "" && tokenJSON.RefreshToken == "" {
+		return nil, fmt.Errorf("token file %s holds neither an access nor a refresh token", token)
+	}
+
+	return &persistingTokenSource{
+		source: oauth2.ReuseTokenSource(tokenJSON, credentialsConfig.TokenSource(ctx, tokenJSON)),
+		path:   token,
+		last:   tokenJSON,
+	}, nil
+}
+
+// persistingTokenSource writes every newly issued token back to the token file so that
+// refreshed access tokens survive restarts.
+type persistingTokenSource struct {
+	mu     sync.Mutex
+	source oauth2.TokenSource
+	path   string
+	last   *oauth2.Token
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tok, err := s.source.Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to refresh token: %w", err)
+	}
+
+	if s.last == nil || tok.AccessToken != s.last.AccessToken || tok.RefreshToken != s.last.RefreshToken {
+		if err = saveToken(s.path, tok); err != nil {
+			return nil, err
+		}
+		s.last = tok
+	}
+
+	return tok, nil
+}
+
+// saveToken atomically replaces the token file with tok.
+func saveToken(path string, tok *oauth2.Token) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("unable to marshal token: %w", err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary token file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
 
-	credentialsClient := credentialsConfig.Client(context.Background(), tokenJSON)
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write token file: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to sync token file: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to close token file: %w", err)
+	}
+	if err = os.Chmod(tmp.Name(), 0600); err != nil {
+		return fmt.Errorf("unable to set token file permissions: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to replace token file: %w", err)
+	}
 
-	return credentialsClient, nil
+	return nil
 }
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestFile writes data to name in dir and returns its path.
+func writeTestFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func oauthClientCredentials(tokenURL string) string {
+	return fmt.Sprintf(`{"installed":{"client_id":"opl-utils","client_secret":"hunter2",`+
+		`"auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":%q,`+
+		`"redirect_uris":["urn:ietf:wg:oauth:2.0:oob"]}}`, tokenURL)
+}
+
+func TestGoogleTokenSourceTokenFileErrors(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	credentials := writeTestFile(t, dir, "credentials.json", oauthClientCredentials("https://oauth2.googleapis.com/token"))
+
+	tests := map[string]struct {
+		token string
+		err   string
+	}{
+		"missing":   {token: filepath.Join(dir, "missing.json"), err: "unable to read token file"},
+		"malformed": {token: writeTestFile(t, dir, "malformed.json", "{"), err: "unable to parse token file"},
+		"empty":     {token: writeTestFile(t, dir, "empty.json", `{"token_type":"Bearer"}`), err: "neither an access nor a refresh token"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := GoogleTokenSource(ctx, credentials, tt.token)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := GoogleTokenSource(ctx, credentials, tests["missing"].token); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing token file: got %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestGoogleTokenSourcePersistsRefreshedToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("refresh_token") != "refresh-1" {
+			http.Error(w, "bad refresh request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"access-2","token_type":"Bearer","expires_in":3600,"refresh_token":"refresh-2"}`)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	credentials := writeTestFile(t, dir, "credentials.json", oauthClientCredentials(srv.URL+"/token"))
+	expired, _ := json.Marshal(&oauth2.Token{
+		AccessToken:  "access-1",
+		TokenType:    "Bearer",
+		RefreshToken: "refresh-1",
+		Expiry:       time.Now().Add(-time.Hour),
+	})
+	token := writeTestFile(t, dir, "token.json", string(expired))
+	if err := os.Chmod(token, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := GoogleTokenSource(context.Background(), credentials, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access-2" {
+		t.Fatalf("got access token %q, want the refreshed one", tok.AccessToken)
+	}
+
+	data, err := ioutil.ReadFile(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := &oauth2.Token{}
+	if err = json.Unmarshal(data, saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != "access-2" || saved.RefreshToken != "refresh-2" {
+		t.Errorf("token file holds %+v, want the refreshed token", saved)
+	}
+
+	info, err := os.Stat(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("token file mode is %o, want 600", mode)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("temporary token files left behind: %d files in %s", len(entries), dir)
+	}
+}
+
+func TestGoogleTokenSourceServiceAccount(t *testing.T) {
+	dir := t.TempDir()
+	credentials := writeTestFile(t, dir, "service-account.json", `{"type":"service_account",`+
+		`"client_email":"opl-utils@opl.iam.gserviceaccount.com","private_key":"unused",`+
+		`"token_uri":"https://oauth2.googleapis.com/token"}`)
+
+	// the token file is not read for service accounts
+	ts, err := GoogleTokenSource(context.Background(), credentials, filepath.Join(dir, "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ts.(*persistingTokenSource); ok {
+		t.Error("service account credentials treated as an OAuth client")
+	}
+}
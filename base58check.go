@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+)
+
+// base58ChecksumSize is the length of the double SHA-256 checksum appended by Base58Check.
+const base58ChecksumSize = 4
+
+func base58Checksum(payload []byte) []byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	return second[:base58ChecksumSize]
+}
+
+// CheckEncodeAlphabet encodes src prefixed with version and suffixed with the first four bytes
+// of its double SHA-256 (Base58Check).
+func CheckEncodeAlphabet(src []byte, version byte, alphabet *Alphabet) string {
+	payload := make([]byte, 0, 1+len(src)+base58ChecksumSize)
+	payload = append(payload, version)
+	payload = append(payload, src...)
+	payload = append(payload, base58Checksum(payload)...)
+
+	return EncodeAlphabet(payload, alphabet)
+}
+
+func CheckEncode(src []byte, version byte) string {
+	return CheckEncodeAlphabet(src, version, BitcoinAlphabet)
+}
+
+// CheckDecodeAlphabet decodes a Base58Check string and returns its payload and version.
+// Failures are reported as *Base58Error.
+func CheckDecodeAlphabet(src string, alphabet *Alphabet) ([]byte, byte, error) {
+	decoded, err := DecodeAlphabet(src, alphabet)
+	if err != nil {
+		if _, ok := err.(*Base58Error); ok {
+			return nil, 0, err
+		}
+		return nil, 0, &Base58Error{Err: ErrBase58Format}
+	}
+
+	if len(decoded) < 1+base58ChecksumSize {
+		return nil, 0, &Base58Error{Err: ErrBase58Format}
+	}
+
+	payload, checksum := decoded[:len(decoded)-base58ChecksumSize], decoded[len(decoded)-base58ChecksumSize:]
+	if !bytes.Equal(base58Checksum(payload), checksum) {
+		return nil, 0, &Base58Error{Err: ErrBase58Checksum}
+	}
+
+	return payload[1:], payload[0], nil
+}
+
+func CheckDecode(src string) ([]byte, byte, error) {
+	return CheckDecodeAlphabet(src, BitcoinAlphabet)
+}
+
+// base58 is not a block encoding, so the streaming encoder and decoder buffer the whole input.
+type base58Encoder struct {
+	w        io.Writer
+	alphabet *Alphabet
+	buf      bytes.Buffer
+	closed   bool
+}
+
+// NewEncoder returns a base58 encoder writing to w. The encoded data is written on Close.
+func NewEncoder(w io.Writer, alphabet *Alphabet) io.WriteCloser {
+	return &base58Encoder{w: w, alphabet: alphabet}
+}
+
+func (e *base58Encoder) Write(p []byte) (int, error) {
+	if e.closed {
+		return 0, io.ErrClosedPipe
+	}
+	return e.buf.Write(p)
+}
+
+func (e *base58Encoder) Close() error {
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+
+	if e.buf.Len() == 0 {
+		return nil
+	}
+	_, err := io.WriteString(e.w, EncodeAlphabet(e.buf.Bytes(), e.alphabet))
+	return err
+}
+
+type base58Decoder struct {
+	r        io.Reader
+	alphabet *Alphabet
+	out      *bytes.Reader
+}
+
+// NewDecoder returns a base58 decoder reading from r. Surrounding whitespace is ignored.
+func NewDecoder(r io.Reader, alphabet *Alphabet) io.Reader {
+	return &base58Decoder{r: r, alphabet: alphabet}
+}
+
+func (d *base58Decoder) Read(p []byte) (int, error) {
+	if d.out == nil {
+		src, err := ioutil.ReadAll(d.r)
+		if err != nil {
+			return 0, err
+		}
+
+		var decoded []byte
+		if src = bytes.TrimSpace(src); len(src) > 0 {
+			if decoded, err = DecodeAlphabet(string(src), d.alphabet); err != nil {
+				return 0, err
+			}
+		}
+		d.out = bytes.NewReader(decoded)
+	}
+
+	return d.out.Read(p)
+}
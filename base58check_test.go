@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCheckEncode(t *testing.T) {
+	// the address of the public key hash from the Bitcoin wiki Base58Check example
+	payload, _ := hex.DecodeString("010966776006953D5567439E5E39F86A0D273BEE")
+	const address = "16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvM"
+
+	if got := CheckEncode(payload, 0); got != address {
+		t.Errorf("CheckEncode = %s, want %s", got, address)
+	}
+
+	decoded, version, err := CheckDecode(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 || !bytes.Equal(decoded, payload) {
+		t.Errorf("CheckDecode = %X, %d, want %X, 0", decoded, version, payload)
+	}
+}
+
+func TestCheckDecodeErrors(t *testing.T) {
+	tests := map[string]struct {
+		src    string
+		err    error
+		offset int
+	}{
+		"bad digit":    {src: "16UwLL9Risc3QfPqBUvKofHmBQ7wMtjv0", err: ErrBase58Digit, offset: 32},
+		"bad checksum": {src: "16UwLL9Risc3QfPqBUvKofHmBQ7wMtjvN", err: ErrBase58Checksum},
+		"too short":    {src: "1111", err: ErrBase58Format},
+		"empty":        {src: "", err: ErrBase58Format},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := CheckDecode(tt.src)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("CheckDecode(%q) = %v, want %v", tt.src, err, tt.err)
+			}
+
+			var b58Err *Base58Error
+			if !errors.As(err, &b58Err) {
+				t.Fatalf("CheckDecode(%q) = %T, want *Base58Error", tt.src, err)
+			}
+			if tt.err == ErrBase58Digit && (b58Err.Digit != '0' || b58Err.Offset != tt.offset) {
+				t.Errorf("bad digit reported as %q at %d", b58Err.Digit, b58Err.Offset)
+			}
+		})
+	}
+}
+
+func TestEncoderDecoder(t *testing.T) {
+	payload := []byte("\x00\x00partner labs")
+
+	var encoded bytes.Buffer
+	enc := NewEncoder(&encoded, FlickrAlphabet)
+	if _, err := enc.Write(payload[:5]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(payload[5:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := EncodeAlphabet(payload, FlickrAlphabet); encoded.String() != want {
+		t.Fatalf("encoder wrote %s, want %s", encoded.String(), want)
+	}
+
+	decoded, err := ioutil.ReadAll(NewDecoder(strings.NewReader(" "+encoded.String()+"\n"), FlickrAlphabet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, payload) {
+		t.Errorf("decoder read %q, want %q", decoded, payload)
+	}
+}
+
+func FuzzEncodeDecode(f *testing.F) {
+	f.Add([]byte{0})
+	f.Add([]byte{0, 0, 0, 1})
+	f.Add([]byte{0, 0, 255, 255, 0})
+	f.Add([]byte("partner labs"))
+
+	f.Fuzz(func(t *testing.T, src []byte) {
+		if len(src) == 0 {
+			return
+		}
+
+		decoded, err := Decode(Encode(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded, src) {
+			t.Fatalf("Decode(Encode(%X)) = %X", src, decoded)
+		}
+	})
+}
+
+func FuzzCheckEncodeDecode(f *testing.F) {
+	f.Add([]byte{}, byte(0))
+	f.Add([]byte{0, 0, 1}, byte(0))
+	f.Add([]byte{0, 0, 0}, byte(111))
+	f.Add([]byte("partner labs"), byte(255))
+
+	f.Fuzz(func(t *testing.T, src []byte, version byte) {
+		decoded, decodedVersion, err := CheckDecode(CheckEncode(src, version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decodedVersion != version || !bytes.Equal(decoded, src) {
+			t.Fatalf("CheckDecode(CheckEncode(%X, %d)) = %X, %d", src, version, decoded, decodedVersion)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"golang.org/x/crypto/blowfish"
+)
+
+// bcryptPBKDFBlockSize is the output size of a single bcrypt hash in bcrypt_pbkdf.
+const bcryptPBKDFBlockSize = 32
+
+var bcryptPBKDFMagic = []byte("OxychromaticBlowfishSwatDynamite")
+
+// bcryptPBKDF derives keyLen bytes from password and salt with the bcrypt_pbkdf function
+// OpenSSH uses to protect private keys. golang.org/x/crypto only ships it as an internal package
+// of x/crypto/ssh, which can decrypt such keys but not create them.
+func bcryptPBKDF(password, salt []byte, rounds, keyLen int) ([]byte, error) {
+	if rounds < 1 {
+		return nil, fmt.Errorf("bcrypt_pbkdf: number of rounds is too small")
+	}
+	if len(password) == 0 {
+		return nil, fmt.Errorf("bcrypt_pbkdf: empty password")
+	}
+	if len(salt) == 0 || len(salt) > 1<<20 {
+		return nil, fmt.Errorf("bcrypt_pbkdf: bad salt length")
+	}
+	if keyLen > 1024 {
+		return nil, fmt.Errorf("bcrypt_pbkdf: keyLen is too large")
+	}
+
+	numBlocks := (keyLen + bcryptPBKDFBlockSize - 1) / bcryptPBKDFBlockSize
+	key := make([]byte, numBlocks*bcryptPBKDFBlockSize)
+
+	h := sha512.New()
+	h.Write(password)
+	shapass := h.Sum(nil)
+
+	shasalt := make([]byte, 0, sha512.Size)
+	cnt, tmp := make([]byte, 4), make([]byte, bcryptPBKDFBlockSize)
+	for block := 1; block <= numBlocks; block++ {
+		h.Reset()
+		h.Write(salt)
+		cnt[0] = byte(block >> 24)
+		cnt[1] = byte(block >> 16)
+		cnt[2] = byte(block >> 8)
+		cnt[3] = byte(block)
+		h.Write(cnt)
+		if err := bcryptHash(tmp, shapass, h.Sum(shasalt)); err != nil {
+			return nil, err
+		}
+
+		out := make([]byte, bcryptPBKDFBlockSize)
+		copy(out, tmp)
+		for i := 2; i <= rounds; i++ {
+			h.Reset()
+			h.Write(tmp)
+			if err := bcryptHash(tmp, shapass, h.Sum(shasalt)); err != nil {
+				return nil, err
+			}
+			for j := 0; j < len(out); j++ {
+				out[j] ^= tmp[j]
+			}
+		}
+
+		// the output blocks are interleaved rather than concatenated
+		for i, v := range out {
+			key[i*numBlocks+(block-1)] = v
+		}
+	}
+
+	return key[:keyLen], nil
+}
+
+func bcryptHash(out, shapass, shasalt []byte) error {
+	c, err := blowfish.NewSaltedCipher(shapass, shasalt)
+	if err != nil {
+		return fmt.Errorf("bcrypt_pbkdf: %w", err)
+	}
+
+	for i := 0; i < 64; i++ {
+		blowfish.ExpandKey(shasalt, c)
+		blowfish.ExpandKey(shapass, c)
+	}
+
+	copy(out, bcryptPBKDFMagic)
+	for i := 0; i < bcryptPBKDFBlockSize; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(out[i:i+8], out[i:i+8])
+		}
+	}
+
+	// blowfish works on big endian words, bcrypt_pbkdf expects little endian ones
+	for i := 0; i < bcryptPBKDFBlockSize; i += 4 {
+		out[i+3], out[i+2], out[i+1], out[i] = out[i], out[i+1], out[i+2], out[i+3]
+	}
+
+	return nil
+}
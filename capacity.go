@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"io"
+	"io/ioutil"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// ClusterSizeLabel records the requested cluster size of a lab on its ClusterDeployment.
+const ClusterSizeLabel = "opl-cluster-size"
+
+// New labs are installed on AWS in us-west-2 until platform and region selection lands.
+const (
+	DefaultLabProvider = "aws"
+	DefaultLabRegion   = "us-west-2"
+)
+
+const (
+	CapacityAdmit  CapacityDecision = "admit"
+	CapacityQueue  CapacityDecision = "queue"
+	CapacityRefuse CapacityDecision = "refuse"
+)
+
+// ClusterSizes is the catalog of cluster sizes, keyed by LabRequest.ClusterSize.
+var ClusterSizes = map[int]ClusterSize{
+	0: {MasterType: "m5.xlarge", WorkerType: "m5.large", MasterReplicas: 3, WorkerReplicas: 3},
+	1: {MasterType: "m5.xlarge", WorkerType: "m5.xlarge", MasterReplicas: 3, WorkerReplicas: 3},
+	2: {MasterType: "m5.xlarge", WorkerType: "m5.2xlarge", MasterReplicas: 3, WorkerReplicas: 3},
+}
+
+// InstanceVCPUs is the vCPU count of the instance types used by labs.
+var InstanceVCPUs = map[string]int{
+	"m5.large":   2,
+	"m5.xlarge":  4,
+	"m5.2xlarge": 8,
+	"m5.4xlarge": 16,
+}
+
+func instanceVCPUs(instanceType string, replicas int) (int, error) {
+	vcpu, ok := InstanceVCPUs[instanceType]
+	if !ok {
+		return 0, fmt.Errorf("unknown instance type %q", instanceType)
+	}
+	return vcpu * replicas, nil
+}
+
+// VCPU returns the vCPU count of a cluster of size s.
+func (s ClusterSize) VCPU() (int, error) {
+	masters, err := instanceVCPUs(s.MasterType, s.MasterReplicas)
+	if err != nil {
+		return 0, err
+	}
+
+	workers, err := instanceVCPUs(s.WorkerType, s.WorkerReplicas)
+	if err != nil {
+		return 0, err
+	}
+
+	return masters + workers, nil
+}
+
+func clusterSize(size int) (ClusterSize, error) {
+	s, ok := ClusterSizes[size]
+	if !ok {
+		return ClusterSize{}, fmt.Errorf("unknown cluster size %d", size)
+	}
+	return s, nil
+}
+
+// LoadCapacityLimits reads the capacity limits from the JSON file at path, keyed by
+// "<provider>/<region>", e.g. {"aws/us-west-2": {"maxVCPU": 512, "maxLabs": 20}}.
+func LoadCapacityLimits(path string) (map[string]CapacityLimit, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read capacity limits file: %w", err)
+	}
+
+	limits := make(map[string]CapacityLimit)
+	if err = json.Unmarshal(data, &limits); err != nil {
+		return nil, fmt.Errorf("unable to parse capacity limits file: %w", err)
+	}
+
+	return limits, nil
+}
+
+// GetCapacityUsage sums the vCPUs and labs of every active ClusterDeployment on the hub per
+// provider region. Workers are counted from the hive MachinePools of a lab, masters and labs
+// without MachinePools from the size catalog. Labs the catalog cannot describe are counted
+// with a fallback and listed in the Warnings of their region. Hibernating labs count as labs
+// but use no vCPUs.
+func GetCapacityUsage(ctx context.Context, limits map[string]CapacityLimit) ([]CapacityUsage, error) {
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return getCapacityUsage(ctx, dc, limits)
+}
+
+func getCapacityUsage(ctx context.Context, dc client.Client, limits map[string]CapacityLimit) ([]CapacityUsage, error) {
+	cdList := hivev1.ClusterDeploymentList{}
+	if err := dc.List(ctx, &cdList, client.InNamespace("hive")); err != nil {
+		return nil, fmt.Errorf("unable to list ClusterDeployments: %w", err)
+	}
+
+	mpList := hivev1.MachinePoolList{}
+	if err := dc.List(ctx, &mpList, client.InNamespace("hive")); err != nil {
+		return nil, fmt.Errorf("unable to list MachinePools: %w", err)
+	}
+
+	machinePools := make(map[string][]hivev1.MachinePool)
+	for _, mp := range mpList.Items {
+		ref := mp.Spec.ClusterDeploymentRef.Name
+		machinePools[ref] = append(machinePools[ref], mp)
+	}
+
+	usage := make(map[string]*CapacityUsage)
+	for key, limit := range limits {
+		provider, region := splitCapacityKey(key)
+		usage[key] = &CapacityUsage{Provider: provider, Region: region, Limit: limit}
+	}
+
+	for _, cd := range cdList.Items {
+		if cd.DeletionTimestamp != nil {
+			continue
+		}
+
+		provider, region := clusterDeploymentRegion(&cd)
+		key := provider + "/" + region
+		if usage[key] == nil {
+			usage[key] = &CapacityUsage{Provider: provider, Region: region}
+		}
+		usage[key].Labs++
+
+		// the machines of hibernating labs are stopped
+		if cd.Spec.PowerState == hivev1.HibernatingClusterPowerState {
+			continue
+		}
+
+		vcpu, warnings, err := clusterDeploymentVCPU(&cd, machinePools[cd.Name])
+		if err != nil {
+			return nil, fmt.Errorf("cluster deployment %s: %w", cd.Name, err)
+		}
+		usage[key].VCPU += vcpu
+		usage[key].Warnings = append(usage[key].Warnings, warnings...)
+	}
+
+	var result []CapacityUsage
+	for _, u := range usage {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Provider != result[j].Provider {
+			return result[i].Provider < result[j].Provider
+		}
+		return result[i].Region < result[j].Region
+	})
+
+	return result, nil
+}
+
+func splitCapacityKey(key string) (string, string) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func clusterDeploymentRegion(cd *hivev1.ClusterDeployment) (string, string) {
+	switch p := cd.Spec.Platform; {
+	case p.AWS != nil:
+		return "aws", p.AWS.Region
+	case p.GCP != nil:
+		return "gcp", p.GCP.Region
+	case p.Azure != nil:
+		return "azure", p.Azure.Region
+	default:
+		return "unknown", ""
+	}
+}
+
+// clusterDeploymentVCPU counts the vCPUs of a lab. Labs created before the ClusterSizeLabel
+// was recorded, and labs whose label names no catalogued size, are counted as the smallest
+// size; machine pools of an uncatalogued instance type are counted as the size's worker type.
+// Every such fallback is returned as a warning.
+func clusterDeploymentVCPU(cd *hivev1.ClusterDeployment, pools []hivev1.MachinePool) (int, []string, error) {
+	var warnings []string
+
+	size := 0
+	if value, ok := cd.Labels[ClusterSizeLabel]; ok {
+		if labelled, err := strconv.Atoi(value); err != nil {
+			warnings = append(warnings, fmt.Sprintf("cluster deployment %s: invalid %s label %q, counted as size %d",
+				cd.Name, ClusterSizeLabel, value, size))
+		} else if _, ok := ClusterSizes[labelled]; !ok {
+			warnings = append(warnings, fmt.Sprintf("cluster deployment %s: unknown cluster size %d, counted as size %d",
+				cd.Name, labelled, size))
+		} else {
+			size = labelled
+		}
+	}
+
+	s, err := clusterSize(size)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(pools) == 0 {
+		vcpu, err := s.VCPU()
+		return vcpu, warnings, err
+	}
+
+	vcpu, err := instanceVCPUs(s.MasterType, s.MasterReplicas)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, mp := range pools {
+		var replicas int
+		switch {
+		case mp.Spec.Autoscaling != nil:
+			replicas = int(mp.Spec.Autoscaling.MaxReplicas)
+		case mp.Spec.Replicas != nil:
+			replicas = int(*mp.Spec.Replicas)
+		}
+
+		instanceType := s.WorkerType
+		if mp.Spec.Platform.AWS != nil && mp.Spec.Platform.AWS.InstanceType != "" {
+			instanceType = mp.Spec.Platform.AWS.InstanceType
+		}
+		if _, ok := InstanceVCPUs[instanceType]; !ok {
+			warnings = append(warnings, fmt.Sprintf("cluster deployment %s: machine pool %s has unknown instance type %q, counted as %s",
+				cd.Name, mp.Name, instanceType, s.WorkerType))
+			instanceType = s.WorkerType
+		}
+
+		workers, err := instanceVCPUs(instanceType, replicas)
+		if err != nil {
+			return 0, nil, fmt.Errorf("machine pool %s: %w", mp.Name, err)
+		}
+		vcpu += workers
+	}
+
+	return vcpu, warnings, nil
+}
+
+// PlanLab decides whether labRequest fits in the capacity left in its region. A lab that
+// fits now is admitted, one that fits once other labs end is queued and one that can never
+// fit the limits is refused.
+func PlanLab(ctx context.Context, labRequest *LabRequest, limits map[string]CapacityLimit) (*CapacityPlan, error) {
+	usage, err := GetCapacityUsage(ctx, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	return planLab(usage, labRequest, DefaultLabProvider, DefaultLabRegion)
+}
+
+func planLab(usage []CapacityUsage, labRequest *LabRequest, provider, region string) (*CapacityPlan, error) {
+	size, err := clusterSize(labRequest.ClusterSize)
+	if err != nil {
+		return nil, err
+	}
+
+	requested, err := size.VCPU()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &CapacityPlan{
+		RequestedVCPU: requested,
+		Usage:         CapacityUsage{Provider: provider, Region: region},
+	}
+	for _, u := range usage {
+		if u.Provider == provider && u.Region == region {
+			plan.Usage = u
+			break
+		}
+	}
+
+	limit := plan.Usage.Limit
+	switch {
+	case limit.MaxVCPU > 0 && requested > limit.MaxVCPU:
+		plan.Decision = CapacityRefuse
+		plan.Reason = fmt.Sprintf("lab needs %d vCPUs, %s/%s allows %d", requested, provider, region, limit.MaxVCPU)
+	case limit.MaxVCPU > 0 && plan.Usage.VCPU+requested > limit.MaxVCPU:
+		plan.Decision = CapacityQueue
+		plan.Reason = fmt.Sprintf("%d of %d vCPUs in use in %s/%s, lab needs %d",
+			plan.Usage.VCPU, limit.MaxVCPU, provider, region, requested)
+	case limit.MaxLabs > 0 && plan.Usage.Labs >= limit.MaxLabs:
+		plan.Decision = CapacityQueue
+		plan.Reason = fmt.Sprintf("%d of %d labs running in %s/%s", plan.Usage.Labs, limit.MaxLabs, provider, region)
+	default:
+		plan.Decision = CapacityAdmit
+	}
+
+	return plan, nil
+}
+
+// WriteCapacityReport writes usage as a table for ops, followed by the warnings of every region.
+func WriteCapacityReport(w io.Writer, usage []CapacityUsage) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROVIDER\tREGION\tLABS\tMAX LABS\tVCPU\tMAX VCPU\tUSED")
+
+	for _, u := range usage {
+		maxLabs, maxVCPU, used := "-", "-", "-"
+		if u.Limit.MaxLabs > 0 {
+			maxLabs = strconv.Itoa(u.Limit.MaxLabs)
+		}
+		if u.Limit.MaxVCPU > 0 {
+			maxVCPU = strconv.Itoa(u.Limit.MaxVCPU)
+			used = fmt.Sprintf("%d%%", u.VCPU*100/u.Limit.MaxVCPU)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%s\t%s\n", u.Provider, u.Region, u.Labs, maxLabs, u.VCPU, maxVCPU, used)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, u := range usage {
+		for _, warning := range u.Warnings {
+			if _, err := fmt.Fprintf(w, "warning: %s/%s: %s\n", u.Provider, u.Region, warning); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	hivev1aws "github.com/openshift/hive/apis/hive/v1/aws"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+// newRegionClusterDeployment returns a lab in region of AWS labelled with size, if any.
+func newRegionClusterDeployment(name, region, size string) *hivev1.ClusterDeployment {
+	cd := &hivev1.ClusterDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "hive"},
+		Spec: hivev1.ClusterDeploymentSpec{
+			Platform: hivev1.Platform{AWS: &hivev1aws.Platform{Region: region}},
+		},
+	}
+	if size != "" {
+		cd.Labels = map[string]string{ClusterSizeLabel: size}
+	}
+	return cd
+}
+
+func TestGetCapacityUsage(t *testing.T) {
+	replicas := int64(2)
+	hibernating := newRegionClusterDeployment("lab-f", "eu-west-1", "large")
+	hibernating.Spec.PowerState = hivev1.HibernatingClusterPowerState
+	dc := newFakeHiveClient(t,
+		newRegionClusterDeployment("lab-a", DefaultLabRegion, "1"),
+		newRegionClusterDeployment("lab-b", DefaultLabRegion, "large"),
+		newRegionClusterDeployment("lab-c", DefaultLabRegion, "7"),
+		newRegionClusterDeployment("lab-d", DefaultLabRegion, ""),
+		newRegionClusterDeployment("lab-e", "eu-west-1", "2"),
+		hibernating,
+		&hivev1.MachinePool{
+			ObjectMeta: metav1.ObjectMeta{Name: "lab-d-worker", Namespace: "hive"},
+			Spec: hivev1.MachinePoolSpec{
+				ClusterDeploymentRef: corev1.LocalObjectReference{Name: "lab-d"},
+				Name:                 "worker",
+				Replicas:             &replicas,
+				Platform:             hivev1.MachinePoolPlatform{AWS: &hivev1aws.MachinePoolPlatform{InstanceType: "m6i.metal"}},
+			},
+		},
+	)
+	limits := map[string]CapacityLimit{
+		"aws/us-west-2": {MaxVCPU: 100, MaxLabs: 10},
+		"aws/us-east-1": {MaxVCPU: 50},
+	}
+
+	usage, err := getCapacityUsage(context.Background(), dc, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 3 || usage[0].Region != "eu-west-1" || usage[1].Region != "us-east-1" || usage[2].Region != "us-west-2" {
+		t.Fatalf("unexpected regions %+v", usage)
+	}
+
+	// lab-f hibernates and is neither counted with vCPUs nor warned about
+	if u := usage[0]; u.Labs != 2 || u.VCPU != 36 || len(u.Warnings) != 0 {
+		t.Errorf("eu-west-1: got %+v", u)
+	}
+	if u := usage[1]; u.Labs != 0 || u.VCPU != 0 || u.Limit.MaxVCPU != 50 {
+		t.Errorf("us-east-1: got %+v", u)
+	}
+
+	// lab-a is size 1, lab-b and lab-c fall back to size 0 and lab-d has masters of size 0
+	// and two uncatalogued workers counted as m5.large
+	u := usage[2]
+	if u.Labs != 4 || u.VCPU != 24+18+18+16 {
+		t.Errorf("us-west-2: got %d labs and %d vCPUs", u.Labs, u.VCPU)
+	}
+	if len(u.Warnings) != 3 {
+		t.Fatalf("us-west-2: got warnings %q", u.Warnings)
+	}
+	for _, lab := range []string{"lab-b", "lab-c", "lab-d"} {
+		if !strings.Contains(strings.Join(u.Warnings, "\n"), "cluster deployment "+lab+":") {
+			t.Errorf("no warning for %s in %q", lab, u.Warnings)
+		}
+	}
+
+	var report bytes.Buffer
+	if err = WriteCapacityReport(&report, usage); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), "warning: aws/us-west-2: cluster deployment lab-d: machine pool lab-d-worker") {
+		t.Errorf("report does not list the fallbacks:\n%s", report.String())
+	}
+}
+
+func TestPlanLab(t *testing.T) {
+	usage := []CapacityUsage{{
+		Provider: DefaultLabProvider,
+		Region:   DefaultLabRegion,
+		Labs:     2,
+		VCPU:     70,
+		Limit:    CapacityLimit{MaxVCPU: 100, MaxLabs: 3},
+	}}
+
+	tests := map[string]struct {
+		size     int
+		usage    []CapacityUsage
+		decision CapacityDecision
+	}{
+		"fits":          {size: 1, usage: usage, decision: CapacityAdmit},
+		"no vCPUs left": {size: 2, usage: usage, decision: CapacityQueue},
+		"no region":     {size: 2, decision: CapacityAdmit},
+		"too many labs": {size: 0, usage: []CapacityUsage{{Provider: DefaultLabProvider, Region: DefaultLabRegion, Labs: 3, Limit: CapacityLimit{MaxLabs: 3}}}, decision: CapacityQueue},
+		"never fits":    {size: 2, usage: []CapacityUsage{{Provider: DefaultLabProvider, Region: DefaultLabRegion, Limit: CapacityLimit{MaxVCPU: 32}}}, decision: CapacityRefuse},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			plan, err := planLab(tt.usage, &LabRequest{ClusterSize: tt.size}, DefaultLabProvider, DefaultLabRegion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if plan.Decision != tt.decision {
+				t.Errorf("got %s (%s), want %s", plan.Decision, plan.Reason, tt.decision)
+			}
+		})
+	}
+
+	if _, err := planLab(usage, &LabRequest{ClusterSize: 7}, DefaultLabProvider, DefaultLabRegion); err == nil {
+		t.Error("unknown cluster size planned")
+	}
+}
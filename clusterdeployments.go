@@ -2,30 +2,56 @@ package utils
 
 import (
 	"context"
+	"fmt"
 	hivev1 "github.com/openshift/hive/apis/hive/v1"
 	"github.com/openshift/hive/apis/hive/v1/aws"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
 	"strings"
 )
 
-func GetClusterDeployments() map[string]interface{} {
+// SSHKeyFingerprintsAnnotation records the SHA256 fingerprints of the partner SSH keys
+// installed on a lab.
+const SSHKeyFingerprintsAnnotation = "opl-ssh-key-fingerprints"
+
+var leaseTimes = []string{"one-day", "one-week", "two-weeks", "one-month"}
+
+// hiveClient creates a controller-runtime client for the hub with the hive types registered.
+func hiveClient() (client.Client, error) {
 	cfg, err := DefaultClientK8sAuthenticate()
-	ErrorCheck("Unable to create default client: %v\n", err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create default client: %w", err)
+	}
 
 	scheme := runtime.NewScheme()
-	err = hivev1.SchemeBuilder.AddToScheme(scheme)
-	ErrorCheck("Unable to add hive to scheme: %v\n", err)
+	if err = hivev1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		return nil, fmt.Errorf("unable to add hive to scheme: %w", err)
+	}
 
 	dc, err := client.New(cfg, client.Options{Scheme: scheme})
-	ErrorCheck("Unable to create K8s client: %v\n", err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create K8s client: %w", err)
+	}
+
+	return dc, nil
+}
+
+func GetClusterDeployments(ctx context.Context) (map[string]interface{}, error) {
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
 
 	cdList := hivev1.ClusterDeploymentList{}
 
-	err = dc.List(context.Background(), &cdList, &client.ListOptions{Namespace: "hive"})
-	ErrorCheck("Unable to list ClusterDeployments: %v\n", err)
+	err = dc.List(ctx, &cdList, &client.ListOptions{Namespace: "hive"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list ClusterDeployments: %w", err)
+	}
 
 	clusterDeployments := make(map[string]interface{})
 
@@ -37,11 +63,18 @@ func GetClusterDeployments() map[string]interface{} {
 			labels[key] = value
 		}
 
+		// clusters that have not finished installing have no metadata yet
+		var adminPasswordSecret, adminKubeconfigSecret string
+		if cd.Spec.ClusterMetadata != nil {
+			adminPasswordSecret = cd.Spec.ClusterMetadata.AdminPasswordSecretRef.Name
+			adminKubeconfigSecret = cd.Spec.ClusterMetadata.AdminKubeconfigSecretRef.Name
+		}
+
 		details = append(details,
 			cd.Name,
 			cd.Status.WebConsoleURL,
-			cd.Spec.ClusterMetadata.AdminPasswordSecretRef.Name,
-			cd.Spec.ClusterMetadata.AdminKubeconfigSecretRef.Name)
+			adminPasswordSecret,
+			adminKubeconfigSecret)
 
 		clusterDeployments[cd.Spec.ClusterName] = map[string]interface{}{
 			"details": details,
@@ -49,23 +82,31 @@ func GetClusterDeployments() map[string]interface{} {
 		}
 	}
 
-	return clusterDeployments
+	return clusterDeployments, nil
 }
 
-func CreateClusterDeployment(labRequest *LabRequest) {
-	cfg, err := DefaultClientK8sAuthenticate()
-	ErrorCheck("Unable to create default client: %v\n", err)
+func CreateClusterDeployment(ctx context.Context, labRequest *LabRequest) error {
+	if labRequest.LeaseTime < 0 || labRequest.LeaseTime >= len(leaseTimes) {
+		return fmt.Errorf("%w: %d", ErrInvalidLease, labRequest.LeaseTime)
+	}
 
-	scheme := runtime.NewScheme()
-	err = hivev1.SchemeBuilder.AddToScheme(scheme)
-	ErrorCheck("Unable to add hive to scheme: %v\n", err)
+	dc, err := hiveClient()
+	if err != nil {
+		return err
+	}
 
-	dc, err := client.New(cfg, client.Options{Scheme: scheme})
-	ErrorCheck("Unable to create K8s client: %v\n", err)
+	kc, err := K8sAuthenticate()
+	if err != nil {
+		return err
+	}
 
-	kc := K8sAuthenticate()
-	labSecret, err := kc.CoreV1().Secrets("hive").Get(context.Background(), labRequest.ID.String(), metav1.GetOptions{})
-	ErrorCheck("Unable to get the lab secret; does it exist: ", err)
+	labSecret, err := kc.CoreV1().Secrets("hive").Get(ctx, labRequest.ID.String(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: %s", ErrLabSecretNotFound, labRequest.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to get the lab secret: %w", err)
+	}
 
 	// TODO: #1 Allow selection of platform; will require some Google Form changes and potentially capturing
 	// information from partner specific to the platform cluster should be installed on
@@ -73,24 +114,43 @@ func CreateClusterDeployment(labRequest *LabRequest) {
 	plat := hivev1.Platform{
 		AWS: &aws.Platform{
 			CredentialsSecretRef: corev1.LocalObjectReference{Name: "hive-aws-creds"},
-			Region:               "us-west-2", // It could be useful to allow region selection based on where partner is
+			Region:               DefaultLabRegion, // It could be useful to allow region selection based on where partner is
 			UserTags:             map[string]string{"LabID": labRequest.ID.String()},
 		},
 	}
 
 	secretRef := corev1.LocalObjectReference{Name: labRequest.ID.String()}
 
-	leasetime := []string{"one-day", "one-week", "two-weeks", "one-month"}
-
 	oplLabels := map[string]string{
 		"opl-region":     labRequest.Availability,
-		"opl-lease-time": leasetime[labRequest.LeaseTime],
+		"opl-lease-time": leaseTimes[labRequest.LeaseTime],
+		ShortIDLabel:     labShortID(labRequest),
+		ClusterSizeLabel: strconv.Itoa(labRequest.ClusterSize),
+	}
+
+	partnerKeys, err := ValidatePublicSSHKeys(labRequest.PublicSSHKey)
+	if err != nil {
+		return err
+	}
+
+	var fingerprints []string
+	for _, key := range partnerKeys {
+		fingerprints = append(fingerprints, key.Fingerprint)
+	}
+
+	oplAnnotations := map[string]string{}
+	if len(fingerprints) > 0 {
+		oplAnnotations[SSHKeyFingerprintsAnnotation] = strings.Join(fingerprints, ",")
+	}
+
+	clusterName, err := LabClusterName(labRequest)
+	if err != nil {
+		return err
 	}
 
-	charsFromID := strings.Split(labRequest.ID.String(), "-")[0]
 	cds := hivev1.ClusterDeploymentSpec{
-		ClusterName: labRequest.ClusterName + "-" + charsFromID,
-		BaseDomain:  "opdev.io",
+		ClusterName: clusterName,
+		BaseDomain:  LabBaseDomain,
 		Platform:    plat,
 		ManageDNS:   false,
 		Provisioning: &hivev1.Provisioning{
@@ -102,13 +162,17 @@ func CreateClusterDeployment(labRequest *LabRequest) {
 
 	cd := hivev1.ClusterDeployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      labRequest.ID.String(),
-			Namespace: "hive",
-			Labels:    oplLabels,
+			Name:        labRequest.ID.String(),
+			Namespace:   "hive",
+			Labels:      oplLabels,
+			Annotations: oplAnnotations,
 		},
 		Spec: cds,
 	}
 
-	err = dc.Create(context.Background(), &cd)
-	ErrorCheck("Unable to create cluster deployment: ", err)
+	if err = dc.Create(ctx, &cd); err != nil {
+		return fmt.Errorf("unable to create cluster deployment: %w", err)
+	}
+
+	return nil
 }
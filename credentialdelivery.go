@@ -0,0 +1,249 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CredentialDeliveryLabel selects the delivery backend of a lab on its ClusterDeployment.
+const CredentialDeliveryLabel = "opl-credential-delivery"
+
+// DefaultCredentialDelivery is the backend used for labs without a CredentialDeliveryLabel.
+const DefaultCredentialDelivery = "privatebin"
+
+// DeliverLabCredentials delivers the credentials of lab labID through the backend named by
+// the lab's CredentialDeliveryLabel. With a non-nil scope the partner receives a scoped
+// ServiceAccount kubeconfig instead of the kubeadmin credentials.
+func DeliverLabCredentials(ctx context.Context, labID string, backends map[string]CredentialDelivery,
+	scope *ScopedKubeconfigOptions) ([]AccessReference, error) {
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
+
+	kc, err := K8sAuthenticate()
+	if err != nil {
+		return nil, err
+	}
+
+	cd := hivev1.ClusterDeployment{}
+	if err = dc.Get(ctx, types.NamespacedName{Namespace: "hive", Name: labID}, &cd); err != nil {
+		return nil, fmt.Errorf("unable to get cluster deployment %s: %w", labID, err)
+	}
+
+	delivery, err := DeliveryForLab(cd.Labels, backends)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := getLabCredentials(ctx, dc, kc, labID)
+	if err != nil {
+		return nil, err
+	}
+
+	if scope != nil {
+		if err = ScopeLabCredentials(ctx, creds, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	return delivery.Deliver(ctx, creds)
+}
+
+// DeliveryForLab picks the backend named by the CredentialDeliveryLabel in labels.
+func DeliveryForLab(labels map[string]string, backends map[string]CredentialDelivery) (CredentialDelivery, error) {
+	name := labels[CredentialDeliveryLabel]
+	if name == "" {
+		name = DefaultCredentialDelivery
+	}
+
+	delivery, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown credential delivery backend %q", name)
+	}
+
+	return delivery, nil
+}
+
+// Deliver pastes the admin password and kubeconfig of creds and returns the paste URLs. When a
+// paste fails, the pastes already created are recorded if RecordPastes is set, or deleted, so
+// none is left behind without a way to revoke it.
+func (d *PrivateBinDelivery) Deliver(ctx context.Context, creds *LabCredentials) ([]AccessReference, error) {
+	pastes := []struct {
+		name    string
+		message string
+	}{
+		{"kubeadmin-password", creds.AdminPassword},
+		{"kubeconfig", string(creds.Kubeconfig)},
+	}
+
+	var (
+		refs      []AccessReference
+		labPastes []LabPaste
+	)
+	for _, paste := range pastes {
+		// scoped credentials come without a kubeadmin password
+		if paste.message == "" {
+			continue
+		}
+
+		var (
+			resp *CreatePasteResponse
+			err  error
+		)
+		if d.Password != "" {
+			resp, err = d.Client.CreatePasteWithPassword(ctx, paste.message, d.Password, d.Config.Expire,
+				d.Config.Formatter, d.Config.OpenDiscussion, d.Config.BurnAfterReading)
+		} else {
+			resp, err = d.Client.CreatePaste(ctx, paste.message, d.Config.Expire,
+				d.Config.Formatter, d.Config.OpenDiscussion, d.Config.BurnAfterReading)
+		}
+		if err != nil {
+			return nil, d.salvage(ctx, creds.LabID, labPastes, &PasteError{LabID: creds.LabID, Err: err})
+		}
+
+		refs = append(refs, AccessReference{Backend: "privatebin", Name: paste.name, Location: resp.URL})
+		labPastes = append(labPastes, LabPaste{
+			ID:          resp.ID,
+			DeleteToken: resp.DeleteToken,
+			Expire:      d.Config.Expire,
+			CreatedAt:   time.Now().UTC(),
+		})
+	}
+
+	if d.RecordPastes {
+		if err := RecordLabPastes(ctx, creds.LabID, labPastes); err != nil {
+			return refs, err
+		}
+	}
+
+	return refs, nil
+}
+
+// salvage records or deletes the pastes created before a delivery failed with err.
+func (d *PrivateBinDelivery) salvage(ctx context.Context, labID string, pastes []LabPaste, err error) error {
+	if len(pastes) == 0 {
+		return err
+	}
+
+	if d.RecordPastes {
+		if recordErr := RecordLabPastes(ctx, labID, pastes); recordErr == nil {
+			return err
+		}
+	}
+
+	for _, paste := range pastes {
+		if deleteErr := d.Client.DeletePaste(ctx, paste.ID, paste.DeleteToken); deleteErr != nil {
+			return fmt.Errorf("%w; unable to delete paste %s: %v", err, paste.ID, deleteErr)
+		}
+	}
+
+	return err
+}
+
+// Deliver writes creds to <mount>/<prefix>/<lab id> and returns that path.
+func (d *VaultDelivery) Deliver(ctx context.Context, creds *LabCredentials) ([]AccessReference, error) {
+	mount := d.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+
+	secretPath := strings.TrimPrefix(strings.Trim(d.PathPrefix, "/")+"/"+creds.LabID, "/")
+
+	body, err := json.Marshal(map[string]interface{}{
+		"data": map[string]string{
+			"cluster_name":       creds.ClusterName,
+			"console_url":        creds.ConsoleURL,
+			"kubeadmin_password": creds.AdminPassword,
+			"kubeconfig":         string(creds.Kubeconfig),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal vault secret: %w", err)
+	}
+
+	endpoint := strings.TrimRight(d.Address, "/") + "/v1/" + mount + "/data/" + secretPath
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create vault request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", d.Token)
+
+	client := d.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: DefaultHTTPTimeout}
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write vault secret: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			fmt.Printf("unable to close connection: %v", err)
+		}
+	}(res.Body)
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read vault response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
+		return nil, fmt.Errorf("vault responds with %q status code: %s", res.Status, resBody)
+	}
+
+	return []AccessReference{{
+		Backend:  "vault",
+		Name:     "credentials",
+		Location: mount + "/" + secretPath,
+	}}, nil
+}
+
+// Deliver stores creds in the lab-<lab id>-credentials secret of Namespace, replacing an
+// earlier delivery, and returns the secret's namespaced name.
+func (d *SecretDelivery) Deliver(ctx context.Context, creds *LabCredentials) ([]AccessReference, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "lab-" + creds.LabID + "-credentials",
+			Namespace: d.Namespace,
+			Labels:    map[string]string{"opl-lab-id": creds.LabID},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"cluster-name":       []byte(creds.ClusterName),
+			"console-url":        []byte(creds.ConsoleURL),
+			"kubeadmin-password": []byte(creds.AdminPassword),
+			"kubeconfig":         creds.Kubeconfig,
+		},
+	}
+
+	secrets := d.Client.CoreV1().Secrets(d.Namespace)
+	_, err := secrets.Create(ctx, secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to store credentials secret: %w", err)
+	}
+
+	return []AccessReference{{
+		Backend:  "secret",
+		Name:     "credentials",
+		Location: d.Namespace + "/" + secret.Name,
+	}}, nil
+}
@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestLabCredentials() *LabCredentials {
+	return &LabCredentials{
+		LabID:         testLabID,
+		ClusterName:   "acme-kr8noc",
+		ConsoleURL:    "https://console-openshift-console.apps.acme-kr8noc.opdev.io",
+		AdminPassword: "Xq7s-Vn2e-Lp9d-Rt4k",
+		Kubeconfig:    []byte("apiVersion: v1\nkind: Config\n"),
+	}
+}
+
+func TestDeliveryForLab(t *testing.T) {
+	backends := map[string]CredentialDelivery{
+		"privatebin": &PrivateBinDelivery{},
+		"vault":      &VaultDelivery{},
+	}
+
+	if d, err := DeliveryForLab(nil, backends); err != nil || d != backends["privatebin"] {
+		t.Errorf("unlabelled lab: got %v, %v", d, err)
+	}
+	if d, err := DeliveryForLab(map[string]string{CredentialDeliveryLabel: "vault"}, backends); err != nil || d != backends["vault"] {
+		t.Errorf("vault lab: got %v, %v", d, err)
+	}
+	if _, err := DeliveryForLab(map[string]string{CredentialDeliveryLabel: "carrier-pigeon"}, backends); err == nil {
+		t.Error("unknown backend accepted")
+	}
+}
+
+func TestPrivateBinDelivery(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("delivered", func(t *testing.T) {
+		pb := newFakePrivateBin(t)
+		d := &PrivateBinDelivery{Client: pb.client(t), Config: &Cfg{Expire: "1day", Formatter: "plaintext"}, Password: "hunter2"}
+		creds := newTestLabCredentials()
+
+		refs, err := d.Deliver(ctx, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refs) != 2 || refs[0].Name != "kubeadmin-password" || refs[1].Name != "kubeconfig" {
+			t.Fatalf("unexpected references %+v", refs)
+		}
+
+		content, err := d.Client.GetPasteWithPassword(ctx, refs[1].Location, "hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content.Paste != string(creds.Kubeconfig) {
+			t.Errorf("kubeconfig paste = %q", content.Paste)
+		}
+	})
+
+	t.Run("scoped credentials", func(t *testing.T) {
+		pb := newFakePrivateBin(t)
+		d := &PrivateBinDelivery{Client: pb.client(t), Config: &Cfg{Expire: "1day"}}
+		creds := newTestLabCredentials()
+		creds.AdminPassword = ""
+
+		refs, err := d.Deliver(ctx, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refs) != 1 || refs[0].Name != "kubeconfig" {
+			t.Errorf("unexpected references %+v", refs)
+		}
+	})
+
+	t.Run("partial failure", func(t *testing.T) {
+		pb := newFakePrivateBin(t)
+		pb.failPost(2)
+		d := &PrivateBinDelivery{Client: pb.client(t), Config: &Cfg{Expire: "1day"}}
+
+		_, err := d.Deliver(ctx, newTestLabCredentials())
+		if !errors.Is(err, ErrPasteFailed) {
+			t.Fatalf("got %v, want ErrPasteFailed", err)
+		}
+		if pb.count() != 0 {
+			t.Errorf("%d pastes left behind", pb.count())
+		}
+	})
+}
+
+func TestVaultDelivery(t *testing.T) {
+	var (
+		path, token string
+		body        struct {
+			Data map[string]string `json:"data"`
+		}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s", r.Method)
+		}
+		path, token = r.URL.Path, r.Header.Get("X-Vault-Token")
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("body: %v", err)
+		}
+		if token != "s.token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"version":1}}`))
+	}))
+	defer srv.Close()
+
+	creds := newTestLabCredentials()
+	d := &VaultDelivery{Address: srv.URL + "/", Token: "s.token", Mount: "kv", PathPrefix: "/partners/", HTTPClient: srv.Client()}
+
+	refs, err := d.Deliver(context.Background(), creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "/v1/kv/data/partners/" + testLabID; path != want {
+		t.Errorf("path = %s, want %s", path, want)
+	}
+	if body.Data["kubeadmin_password"] != creds.AdminPassword || body.Data["kubeconfig"] != string(creds.Kubeconfig) ||
+		body.Data["console_url"] != creds.ConsoleURL || body.Data["cluster_name"] != creds.ClusterName {
+		t.Errorf("unexpected secret data %+v", body.Data)
+	}
+	if len(refs) != 1 || refs[0].Backend != "vault" || refs[0].Location != "kv/partners/"+testLabID {
+		t.Errorf("unexpected references %+v", refs)
+	}
+
+	d.Token = "s.revoked"
+	if _, err = d.Deliver(context.Background(), creds); err == nil {
+		t.Error("delivery with a rejected token succeeded")
+	}
+}
+
+func TestSecretDelivery(t *testing.T) {
+	ctx := context.Background()
+	kc := k8sfake.NewSimpleClientset()
+	d := &SecretDelivery{Client: kc, Namespace: "acme"}
+	creds := newTestLabCredentials()
+
+	refs, err := d.Deliver(ctx, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := "lab-" + testLabID + "-credentials"
+	if len(refs) != 1 || refs[0].Location != "acme/"+name {
+		t.Errorf("unexpected references %+v", refs)
+	}
+
+	// delivering again replaces the credentials
+	creds.AdminPassword = "rotated"
+	if _, err = d.Deliver(ctx, creds); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := kc.CoreV1().Secrets("acme").Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["kubeadmin-password"]) != "rotated" || string(secret.Data["kubeconfig"]) != string(creds.Kubeconfig) {
+		t.Errorf("unexpected secret data %v", secret.Data)
+	}
+	if secret.Labels["opl-lab-id"] != testLabID {
+		t.Errorf("labels = %v", secret.Labels)
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrLabSecretNotFound is returned when the per-lab secret holding the install-config
+	// and SSH key does not exist on the hub.
+	ErrLabSecretNotFound = errors.New("lab secret not found")
+
+	// ErrInvalidLease is returned when a LabRequest has a lease time outside of the known lease options.
+	ErrInvalidLease = errors.New("invalid lease time")
+
+	// ErrWeakSSHKey is returned for public SSH keys of an algorithm or size we do not install.
+	ErrWeakSSHKey = errors.New("weak ssh key")
+
+	// ErrUnsupportedSSHKey is returned for public SSH keys of a type we do not know, such as certificates.
+	ErrUnsupportedSSHKey = errors.New("unsupported ssh key type")
+
+	// ErrPasteNotFound is returned when PrivateBin does not know a paste, because it never
+	// existed, expired or was already deleted.
+	ErrPasteNotFound = errors.New("paste does not exist")
+
+	// ErrLabNotFound is returned when no lab on the hub matches a short ID.
+	ErrLabNotFound = errors.New("lab not found")
+
+	// ErrLabNotInstalled is returned when credentials are requested for a lab whose cluster
+	// has not finished installing.
+	ErrLabNotInstalled = errors.New("lab is not installed")
+
+	// ErrPastesAlreadyIssued is returned when a lab still has live credential pastes and
+	// re-issuing them was not requested.
+	ErrPastesAlreadyIssued = errors.New("lab credential pastes already issued")
+
+	// ErrPasteFailed is returned when lab credentials could not be pasted to PrivateBin.
+	ErrPasteFailed = errors.New("paste failed")
+
+	// ErrBase58Digit is returned when a base58 string contains a character outside of its alphabet.
+	ErrBase58Digit = errors.New("invalid base58 digit")
+
+	// ErrBase58Checksum is returned when the checksum of a Base58Check string does not match its payload.
+	ErrBase58Checksum = errors.New("invalid base58 checksum")
+
+	// ErrBase58Format is returned when a Base58Check string is too short to hold a version and checksum.
+	ErrBase58Format = errors.New("invalid base58check format")
+)
+
+// PasteError describes a failure to paste the credentials of a single lab.
+// It matches ErrPasteFailed with errors.Is.
+type PasteError struct {
+	LabID string
+	Err   error
+}
+
+func (e *PasteError) Error() string {
+	return fmt.Sprintf("unable to paste credentials for lab %s: %v", e.LabID, e.Err)
+}
+
+func (e *PasteError) Unwrap() error {
+	return e.Err
+}
+
+func (e *PasteError) Is(target error) bool {
+	return target == ErrPasteFailed
+}
+
+// Base58Error describes a failure to decode a base58 or Base58Check string. Err is one of
+// ErrBase58Digit, ErrBase58Checksum or ErrBase58Format; Digit and Offset locate a bad digit.
+type Base58Error struct {
+	Digit  rune
+	Offset int
+	Err    error
+}
+
+func (e *Base58Error) Error() string {
+	if e.Err == ErrBase58Digit {
+		return fmt.Sprintf("%v (%q) at offset %d", e.Err, e.Digit, e.Offset)
+	}
+	return e.Err.Error()
+}
+
+func (e *Base58Error) Unwrap() error {
+	return e.Err
+}
@@ -18,9 +18,13 @@ var (
 	FlickrAlphabet, _ = NewAlphabet("123456789abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ")
 )
 
+// ErrorCheck logs err with message and reports whether err was nil.
+//
+// Deprecated: return wrapped errors to the caller instead.
 func ErrorCheck(message string, err error) (ok bool) {
 	if err != nil {
 		log.Printf("%s: %v", message, err)
+		return false
 	}
 	return true
 }
@@ -34,17 +38,23 @@ func Contains(s []string, str string) bool {
 	return false
 }
 
-func RemoveArtifacts(artifacts []string) {
+// RemoveArtifacts removes every artifact and returns the first removal error, if any.
+func RemoveArtifacts(artifacts []string) error {
+	var firstErr error
 	for _, artifact := range artifacts {
-		err := os.Remove(artifact)
-		ErrorCheck("Unable to remove file: %v", err)
+		if err := os.Remove(artifact); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("unable to remove file: %w", err)
+		}
 	}
+	return firstErr
 }
 
-func getDocStatus(service *docs.Service, id string) int {
+func getDocStatus(service *docs.Service, id string) (int, error) {
 	docStatus, err := service.Documents.Get(id).Do()
-	ErrorCheck("Unable to make Get call for Google Doc: ", err)
-	return docStatus.HTTPStatusCode
+	if err != nil {
+		return 0, fmt.Errorf("unable to make Get call for Google Doc: %w", err)
+	}
+	return docStatus.HTTPStatusCode, nil
 }
 
 func NewAlphabet(src string) (*Alphabet, error) {
@@ -143,13 +153,9 @@ func DecodeAlphabet(src string, alphabet *Alphabet) ([]byte, error) {
 		zcount++
 	}
 
-	for _, r := range b58u {
-		if r > 127 {
-			return nil, fmt.Errorf("high-bit set on invalid digit")
-		}
-
-		if alphabet.Decode[r] == -1 {
-			return nil, fmt.Errorf("invalid base58 digit (%q)", r)
+	for i, r := range b58u {
+		if r > 127 || alphabet.Decode[r] == -1 {
+			return nil, &Base58Error{Digit: r, Offset: i, Err: ErrBase58Digit}
 		}
 
 		c = uint64(alphabet.Decode[r])
@@ -212,6 +218,10 @@ func Validate(CurrentLabRequest string) LabRequest {
 
 	// validate the request
 	validate := validator.New()
+	err = validate.RegisterValidation("sshkeys", validateSSHKeysField)
+	if err != nil {
+		log.Fatal(err)
+	}
 	err = validate.Struct(labRequest)
 	if err != nil {
 		fmt.Printf("Unable to validate the request: %v", err)
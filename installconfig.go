@@ -1,27 +1,133 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"os"
 	"strings"
 	"text/template"
 )
 
-func GenerateInstallConfig(labRequest *LabRequest) []byte {
-	charsFromID := strings.Split(labRequest.ID.String(), "-")[0]
+// OpsSSHKeySecretEnv names the environment variable holding the "namespace/name" of the secret
+// with the lab operations public SSH key; DefaultOpsSSHKeySecret is used when it is unset.
+const (
+	OpsSSHKeySecretEnv     = "OPL_OPS_SSH_KEY_SECRET"
+	DefaultOpsSSHKeySecret = "hive/opl-ops-ssh-key"
+)
+
+// GetOpsSSHKey reads the lab operations public SSH key(s) from the "ssh-publickey" entry of the
+// configured secret on the hub.
+func GetOpsSSHKey(ctx context.Context) (string, error) {
+	kc, err := K8sAuthenticate()
+	if err != nil {
+		return "", err
+	}
+
+	return getOpsSSHKey(ctx, kc)
+}
+
+func getOpsSSHKey(ctx context.Context, kc kubernetes.Interface) (string, error) {
+	secretRef := os.Getenv(OpsSSHKeySecretEnv)
+	if secretRef == "" {
+		secretRef = DefaultOpsSSHKeySecret
+	}
+
+	parts := strings.SplitN(secretRef, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("%s must be namespace/name, got %q", OpsSSHKeySecretEnv, secretRef)
+	}
+
+	secret, err := kc.CoreV1().Secrets(parts[0]).Get(ctx, parts[1], metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("unable to get ops ssh key secret %s: %w", secretRef, err)
+	}
 
-	clustersizes := map[int][]string{
-		0: {"m5.xlarge", "m5.large"},
-		1: {"m5.xlarge", "m5.xlarge"},
-		2: {"m5.xlarge", "m5.2xlarge"},
+	key := string(secret.Data["ssh-publickey"])
+	if strings.TrimSpace(key) == "" {
+		return "", fmt.Errorf("ops ssh key secret %s has no ssh-publickey", secretRef)
+	}
+
+	return key, nil
+}
+
+// MergeSSHKeys validates every key, each of which may hold several authorized_keys lines, and
+// returns them in order with duplicates removed.
+func MergeSSHKeys(keys ...string) ([]string, error) {
+	var merged []string
+	seen := make(map[string]bool)
+
+	for _, key := range keys {
+		validated, err := ValidatePublicSSHKeys(key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range validated {
+			if seen[k.Fingerprint] {
+				continue
+			}
+			seen[k.Fingerprint] = true
+			merged = append(merged, k.AuthorizedKey)
+		}
+	}
+
+	return merged, nil
+}
+
+// installConfigSSHKey renders keys as a YAML scalar for the sshKey field of an install-config.
+// The double-quoted form keeps the newlines between keys without depending on the indentation
+// of the template; JSON strings are valid YAML double-quoted scalars.
+func installConfigSSHKey(keys []string) string {
+	if len(keys) <= 1 {
+		return strings.Join(keys, "")
+	}
+
+	quoted, _ := json.Marshal(strings.Join(keys, "\n"))
+	return string(quoted)
+}
+
+// GenerateLabInstallConfig renders the install-config of labRequest with the partner's keys and
+// the ops key of GetOpsSSHKey, so lab operations keep break-glass access to every lab.
+func GenerateLabInstallConfig(ctx context.Context, labRequest *LabRequest) ([]byte, error) {
+	opsKey, err := GetOpsSSHKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return GenerateInstallConfig(labRequest, opsKey)
+}
+
+// GenerateInstallConfig renders the install-config of labRequest. The partner's public keys
+// and opsKeys are all installed; GenerateLabInstallConfig adds the ops key from the hub. The
+// machine types and replicas come from the ClusterSizes catalog.
+func GenerateInstallConfig(labRequest *LabRequest, opsKeys ...string) ([]byte, error) {
+	size, err := clusterSize(labRequest.ClusterSize)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterName, err := LabClusterName(labRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	sshKeys, err := MergeSSHKeys(append([]string{labRequest.PublicSSHKey}, opsKeys...)...)
+	if err != nil {
+		return nil, err
 	}
 
 	ic := InstallConfig{
-		ClusterName:  labRequest.ClusterName + "-" + charsFromID,
-		PublicSSHKey: labRequest.PublicSSHKey,
-		MasterSize:   clustersizes[labRequest.ClusterSize][0],
-		WorkerSize:   clustersizes[labRequest.ClusterSize][1],
+		ClusterName:    clusterName,
+		PublicSSHKey:   installConfigSSHKey(sshKeys),
+		PublicSSHKeys:  sshKeys,
+		MasterSize:     size.MasterType,
+		WorkerSize:     size.WorkerType,
+		MasterReplicas: size.MasterReplicas,
+		WorkerReplicas: size.WorkerReplicas,
 	}
 
 	tmpfile := "/tmp/" + labRequest.ID.String() + ".ic"
@@ -32,24 +138,29 @@ func GenerateInstallConfig(labRequest *LabRequest) []byte {
 
 	t, err := template.New("install-config.tmpl").ParseFiles(paths...)
 	if err != nil {
-		log.Fatalf("Unable to parse template: %v\n", err)
+		return nil, fmt.Errorf("unable to parse template: %w", err)
 	}
 
 	// TODO: #2 Explore options to not create file
 	icfile, err := os.OpenFile(tmpfile, os.O_RDWR|os.O_CREATE, 0755)
-	ErrorCheck("Unable to open lab request tmp install-config: ", err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open lab request tmp install-config: %w", err)
+	}
 
 	err = t.Execute(icfile, ic)
 	if err != nil {
-		log.Fatalf("Unable to construct template: %v\n", err)
+		icfile.Close()
+		return nil, fmt.Errorf("unable to construct template: %w", err)
 	}
 
 	if err = icfile.Close(); err != nil {
-		log.Fatalf("FATALITY! Unable to close lab request tmp install-config: %v", err)
+		return nil, fmt.Errorf("unable to close lab request tmp install-config: %w", err)
 	}
 
 	data, err := ioutil.ReadFile(tmpfile)
-	ErrorCheck("Unable to read lab request tmp install-config: ", err)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read lab request tmp install-config: %w", err)
+	}
 
-	return data
+	return data, nil
 }
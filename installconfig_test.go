@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestInstallConfigSSHKeyTemplate(t *testing.T) {
+	partner, err := GenerateSSHKeyPair(SSHKeyEd25519, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops, err := GenerateSSHKeyPair(SSHKeyECDSAP256, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the sshKey line of an existing install-config template
+	tmpl := template.Must(template.New("install-config").Parse("apiVersion: v1\nsshKey: {{.PublicSSHKey}}\n"))
+
+	for _, keys := range [][]string{
+		{string(partner.PublicKey)},
+		{string(partner.PublicKey), string(ops.PublicKey), string(partner.PublicKey)},
+	} {
+		merged, err := MergeSSHKeys(keys...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var rendered bytes.Buffer
+		if err = tmpl.Execute(&rendered, InstallConfig{PublicSSHKey: installConfigSSHKey(merged)}); err != nil {
+			t.Fatal(err)
+		}
+
+		var ic struct {
+			SSHKey string `json:"sshKey"`
+		}
+		if err = yaml.Unmarshal(rendered.Bytes(), &ic); err != nil {
+			t.Fatalf("rendered invalid YAML %q: %v", rendered.String(), err)
+		}
+		if want := strings.Join(merged, "\n"); ic.SSHKey != want {
+			t.Errorf("sshKey = %q, want %q", ic.SSHKey, want)
+		}
+	}
+}
+
+func TestGetOpsSSHKey(t *testing.T) {
+	ctx := context.Background()
+	kc := k8sfake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "opl-ops-ssh-key", Namespace: "hive"},
+		Data:       map[string][]byte{"ssh-publickey": []byte("ssh-ed25519 AAAA ops")},
+	})
+
+	t.Setenv(OpsSSHKeySecretEnv, "")
+	if key, err := getOpsSSHKey(ctx, kc); err != nil || key != "ssh-ed25519 AAAA ops" {
+		t.Errorf("default secret: got %q, %v", key, err)
+	}
+
+	t.Setenv(OpsSSHKeySecretEnv, "hive/missing")
+	if _, err := getOpsSSHKey(ctx, kc); err == nil {
+		t.Error("missing secret accepted")
+	}
+
+	t.Setenv(OpsSSHKeySecretEnv, "no-namespace")
+	if _, err := getOpsSSHKey(ctx, kc); err == nil {
+		t.Error("malformed secret reference accepted")
+	}
+}
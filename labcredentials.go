@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GetLabCredentials collects the admin password, admin kubeconfig and SSH private key of
+// lab labID from the hub. The lab must have finished installing.
+func GetLabCredentials(ctx context.Context, labID string) (*LabCredentials, error) {
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
+
+	kc, err := K8sAuthenticate()
+	if err != nil {
+		return nil, err
+	}
+
+	return getLabCredentials(ctx, dc, kc, labID)
+}
+
+func getLabCredentials(ctx context.Context, dc client.Client, kc kubernetes.Interface, labID string) (*LabCredentials, error) {
+	cd := hivev1.ClusterDeployment{}
+	if err := dc.Get(ctx, types.NamespacedName{Namespace: "hive", Name: labID}, &cd); err != nil {
+		return nil, fmt.Errorf("unable to get cluster deployment %s: %w", labID, err)
+	}
+
+	if cd.Spec.ClusterMetadata == nil {
+		return nil, fmt.Errorf("lab %s has no cluster metadata; is it installed", labID)
+	}
+
+	adminPasswordSecret, err := kc.CoreV1().Secrets("hive").Get(ctx,
+		cd.Spec.ClusterMetadata.AdminPasswordSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get admin password secret: %w", err)
+	}
+
+	kubeconfigSecret, err := kc.CoreV1().Secrets("hive").Get(ctx,
+		cd.Spec.ClusterMetadata.AdminKubeconfigSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get kubeconfig secret: %w", err)
+	}
+
+	creds := &LabCredentials{
+		LabID:         labID,
+		ClusterName:   cd.Spec.ClusterName,
+		ConsoleURL:    cd.Status.WebConsoleURL,
+		AdminPassword: string(adminPasswordSecret.Data["password"]),
+		Kubeconfig:    kubeconfigSecret.Data["kubeconfig"],
+	}
+
+	// the lab secret is optional; labs created by hand may not have one
+	labSecret, err := kc.CoreV1().Secrets("hive").Get(ctx, labID, metav1.GetOptions{})
+	if err == nil {
+		creds.SSHPrivateKey = labSecret.Data["ssh-privatekey"]
+	}
+
+	return creds, nil
+}
+
+// PasteLabCredentials pastes creds as a single paste, with the admin password, kubeconfig and
+// SSH private key as named attachments, using the expiry and paste options of config.
+func PasteLabCredentials(ctx context.Context, pbc *PBClient, creds *LabCredentials, password string,
+	config *Cfg) (*CreatePasteResponse, error) {
+	message := fmt.Sprintf("Credentials for lab %s\nCluster: %s\nConsole: %s\n",
+		creds.LabID, creds.ClusterName, creds.ConsoleURL)
+
+	var attachments []PasteAttachment
+	if creds.AdminPassword != "" {
+		attachments = append(attachments,
+			PasteAttachment{Name: "kubeadmin-password", MediaType: "text/plain", Data: []byte(creds.AdminPassword)})
+	}
+	attachments = append(attachments,
+		PasteAttachment{Name: "kubeconfig", MediaType: "application/yaml", Data: creds.Kubeconfig})
+	if len(creds.SSHPrivateKey) > 0 {
+		attachments = append(attachments,
+			PasteAttachment{Name: "ssh-privatekey", MediaType: "application/x-pem-file", Data: creds.SSHPrivateKey})
+	}
+
+	resp, err := pbc.CreatePasteWithAttachments(ctx, message, attachments, password,
+		config.Expire, config.Formatter, config.OpenDiscussion, config.BurnAfterReading)
+	if err != nil {
+		return nil, &PasteError{LabID: creds.LabID, Err: err}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,292 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
+)
+
+// LabPastesAnnotation is the ClusterDeployment annotation holding the JSON encoded LabPaste
+// records of the credential pastes created for a lab.
+const LabPastesAnnotation = "opl-pastes"
+
+// RecordLabPastes appends pastes to the paste records of lab labID on the hub.
+func RecordLabPastes(ctx context.Context, labID string, pastes []LabPaste) error {
+	dc, err := hiveClient()
+	if err != nil {
+		return err
+	}
+
+	return recordLabPastes(ctx, dc, labID, pastes)
+}
+
+// GetLabPastes returns the recorded credential pastes of lab labID.
+func GetLabPastes(ctx context.Context, labID string) ([]LabPaste, error) {
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
+
+	cd := hivev1.ClusterDeployment{}
+	if err = dc.Get(ctx, types.NamespacedName{Namespace: "hive", Name: labID}, &cd); err != nil {
+		return nil, fmt.Errorf("unable to get cluster deployment %s: %w", labID, err)
+	}
+
+	return labPastesFromAnnotations(cd.Annotations)
+}
+
+// RevokeLabPastes deletes every recorded credential paste of lab labID from PrivateBin and
+// forgets the pastes that were deleted. Pastes PrivateBin no longer knows about, e.g. because
+// they expired or were burnt after reading, count as deleted.
+func RevokeLabPastes(ctx context.Context, pbc *PBClient, labID string) error {
+	dc, err := hiveClient()
+	if err != nil {
+		return err
+	}
+
+	return revokeLabPastes(ctx, dc, pbc, labID)
+}
+
+// RevokePastesOnReclaim returns a StateHook that revokes the credential pastes of a lab
+// once it is reclaimed. The lab is the ClusterDeployment named by the form's Clusterid.
+func RevokePastesOnReclaim(pbc *PBClient) StateHook {
+	return func(ctx context.Context, form *RequestForm, transition StateTransition) error {
+		if transition.To != StateReclaimed {
+			return nil
+		}
+		if form.Clusterid == "" {
+			return fmt.Errorf("request form %s has no cluster id", form.ID)
+		}
+		return RevokeLabPastes(ctx, pbc, form.Clusterid)
+	}
+}
+
+func recordLabPastes(ctx context.Context, dc client.Client, labID string, pastes []LabPaste) error {
+	return updateLabPastes(ctx, dc, labID, func(recorded []LabPaste) []LabPaste {
+		return append(recorded, pastes...)
+	})
+}
+
+// salvageLabPastes handles the pastes a lab already got when creating the next one failed
+// with err: they are recorded so they can still be revoked, or deleted if that fails.
+func salvageLabPastes(ctx context.Context, dc client.Client, pbc *PBClient, labID string, pastes []LabPaste,
+	err error) error {
+	if len(pastes) == 0 {
+		return err
+	}
+
+	recordErr := recordLabPastes(ctx, dc, labID, pastes)
+	if recordErr == nil {
+		return err
+	}
+
+	for _, paste := range pastes {
+		if deleteErr := pbc.DeletePaste(ctx, paste.ID, paste.DeleteToken); deleteErr != nil {
+			return fmt.Errorf("%w; unable to record (%v) or delete (%v) paste %s", err, recordErr, deleteErr, paste.ID)
+		}
+	}
+
+	return err
+}
+
+func revokeLabPastes(ctx context.Context, dc client.Client, pbc *PBClient, labID string) error {
+	cd := hivev1.ClusterDeployment{}
+	if err := dc.Get(ctx, types.NamespacedName{Namespace: "hive", Name: labID}, &cd); err != nil {
+		return fmt.Errorf("unable to get cluster deployment %s: %w", labID, err)
+	}
+
+	pastes, err := labPastesFromAnnotations(cd.Annotations)
+	if err != nil {
+		return err
+	}
+
+	var revokeErr error
+	revoked := make(map[string]bool)
+	for _, paste := range pastes {
+		err := pbc.DeletePaste(ctx, paste.ID, paste.DeleteToken)
+		if err != nil && !errors.Is(err, ErrPasteNotFound) {
+			if revokeErr == nil {
+				revokeErr = fmt.Errorf("unable to delete paste %s of lab %s: %w", paste.ID, labID, err)
+			}
+			continue
+		}
+		revoked[paste.ID] = true
+	}
+
+	err = updateLabPastes(ctx, dc, labID, func(recorded []LabPaste) []LabPaste {
+		var remaining []LabPaste
+		for _, paste := range recorded {
+			if !revoked[paste.ID] {
+				remaining = append(remaining, paste)
+			}
+		}
+		return remaining
+	})
+	if err != nil {
+		return err
+	}
+
+	return revokeErr
+}
+
+// updateLabPastes rewrites the paste records of a lab with update, retrying on conflicts.
+func updateLabPastes(ctx context.Context, dc client.Client, labID string, update func([]LabPaste) []LabPaste) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cd := hivev1.ClusterDeployment{}
+		if err := dc.Get(ctx, types.NamespacedName{Namespace: "hive", Name: labID}, &cd); err != nil {
+			return fmt.Errorf("unable to get cluster deployment %s: %w", labID, err)
+		}
+
+		recorded, err := labPastesFromAnnotations(cd.Annotations)
+		if err != nil {
+			return err
+		}
+
+		pastes := update(recorded)
+		if cd.Annotations == nil {
+			cd.Annotations = make(map[string]string)
+		}
+		if len(pastes) == 0 {
+			delete(cd.Annotations, LabPastesAnnotation)
+		} else {
+			data, err := json.Marshal(pastes)
+			if err != nil {
+				return fmt.Errorf("unable to marshal lab pastes: %w", err)
+			}
+			cd.Annotations[LabPastesAnnotation] = string(data)
+		}
+
+		return dc.Update(ctx, &cd)
+	})
+}
+
+func labPastesFromAnnotations(annotations map[string]string) ([]LabPaste, error) {
+	var pastes []LabPaste
+	data, ok := annotations[LabPastesAnnotation]
+	if !ok || data == "" {
+		return pastes, nil
+	}
+
+	if err := json.Unmarshal([]byte(data), &pastes); err != nil {
+		return nil, fmt.Errorf("unable to parse %s annotation: %w", LabPastesAnnotation, err)
+	}
+
+	return pastes, nil
+}
+
+// pasteLifetimes maps the PrivateBin expire options to paste lifetimes; "never" maps to zero.
+var pasteLifetimes = map[string]time.Duration{
+	"5min":   5 * time.Minute,
+	"10min":  10 * time.Minute,
+	"1hour":  time.Hour,
+	"1day":   24 * time.Hour,
+	"1week":  7 * 24 * time.Hour,
+	"1month": 30 * 24 * time.Hour,
+	"1year":  365 * 24 * time.Hour,
+	"never":  0,
+}
+
+// ExpiresAt returns when the paste expires, or the zero time if it never does.
+func (p *LabPaste) ExpiresAt() time.Time {
+	lifetime := pasteLifetimes[p.Expire]
+	if lifetime == 0 {
+		return time.Time{}
+	}
+	return p.CreatedAt.Add(lifetime)
+}
+
+// Expired reports whether the paste has expired at now.
+func (p *LabPaste) Expired(now time.Time) bool {
+	expires := p.ExpiresAt()
+	return !expires.IsZero() && !now.Before(expires)
+}
+
+// GenerateLabPastes pastes the admin password and kubeconfig of lab labID, once the lab is
+// installed, and records the delete tokens of the pastes on the hub. A lab that still has live
+// pastes gets ErrPastesAlreadyIssued unless reissue is set, in which case the live pastes are
+// revoked before new ones are created.
+func GenerateLabPastes(ctx context.Context, labID string, config *Cfg, reissue bool) (*LabPasteResult, error) {
+	pbc, err := NewPBClientFromCfg(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
+
+	kc, err := K8sAuthenticate()
+	if err != nil {
+		return nil, err
+	}
+
+	return generateLabPastes(ctx, dc, kc, pbc, labID, config, reissue)
+}
+
+func generateLabPastes(ctx context.Context, dc client.Client, kc kubernetes.Interface, pbc *PBClient, labID string,
+	config *Cfg, reissue bool) (*LabPasteResult, error) {
+	cd := hivev1.ClusterDeployment{}
+	if err := dc.Get(ctx, types.NamespacedName{Namespace: "hive", Name: labID}, &cd); err != nil {
+		return nil, fmt.Errorf("unable to get cluster deployment %s: %w", labID, err)
+	}
+
+	if !cd.Spec.Installed {
+		return nil, fmt.Errorf("%w: %s", ErrLabNotInstalled, labID)
+	}
+
+	recorded, err := labPastesFromAnnotations(cd.Annotations)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for _, paste := range recorded {
+		if paste.Expired(now) {
+			continue
+		}
+		if !reissue {
+			return nil, fmt.Errorf("%w: %s", ErrPastesAlreadyIssued, labID)
+		}
+		if err = revokeLabPastes(ctx, dc, pbc, labID); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	creds, err := getLabCredentials(ctx, dc, kc, labID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &LabPasteResult{LabID: labID}
+	for i, message := range []string{creds.AdminPassword, string(creds.Kubeconfig)} {
+		resp, err := pbc.CreatePaste(ctx, message, config.Expire, config.Formatter,
+			config.OpenDiscussion, config.BurnAfterReading)
+		if err != nil {
+			return nil, salvageLabPastes(ctx, dc, pbc, labID, result.Pastes, &PasteError{LabID: labID, Err: err})
+		}
+
+		paste := LabPaste{ID: resp.ID, DeleteToken: resp.DeleteToken, Expire: config.Expire, CreatedAt: now}
+		result.Pastes = append(result.Pastes, paste)
+		result.Expires = paste.ExpiresAt()
+		if i == 0 {
+			result.PasswordURL = resp.URL
+		} else {
+			result.KubeconfigURL = resp.URL
+		}
+	}
+
+	if err = recordLabPastes(ctx, dc, labID, result.Pastes); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"testing"
+)
+
+const testLabID = "0b1719bb-613e-429e-b843-f573840ed9bd"
+
+func newFakeHiveClient(t *testing.T, objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	if err := hivev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+func newTestClusterDeployment() *hivev1.ClusterDeployment {
+	return &hivev1.ClusterDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: testLabID, Namespace: "hive"},
+		Spec: hivev1.ClusterDeploymentSpec{
+			ClusterName: "acme-kr8noc",
+			Installed:   true,
+			ClusterMetadata: &hivev1.ClusterMetadata{
+				AdminPasswordSecretRef:   corev1.LocalObjectReference{Name: "acme-admin-password"},
+				AdminKubeconfigSecretRef: corev1.LocalObjectReference{Name: "acme-admin-kubeconfig"},
+			},
+		},
+	}
+}
+
+func TestSalvageLabPastes(t *testing.T) {
+	ctx := context.Background()
+	pasteErr := &PasteError{LabID: testLabID, Err: errors.New("storage full")}
+
+	t.Run("recorded", func(t *testing.T) {
+		pb := newFakePrivateBin(t)
+		pbc := pb.client(t)
+		dc := newFakeHiveClient(t, newTestClusterDeployment())
+
+		resp, err := pbc.CreatePaste(ctx, "password", "1day", "plaintext", false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pastes := []LabPaste{{ID: resp.ID, DeleteToken: resp.DeleteToken, Expire: "1day"}}
+		if err = salvageLabPastes(ctx, dc, pbc, testLabID, pastes, pasteErr); !errors.Is(err, ErrPasteFailed) {
+			t.Errorf("got %v, want the paste error", err)
+		}
+
+		cd := hivev1.ClusterDeployment{}
+		if err = dc.Get(ctx, client.ObjectKey{Namespace: "hive", Name: testLabID}, &cd); err != nil {
+			t.Fatal(err)
+		}
+		recorded, err := labPastesFromAnnotations(cd.Annotations)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recorded) != 1 || recorded[0].DeleteToken != resp.DeleteToken {
+			t.Errorf("recorded %+v", recorded)
+		}
+	})
+
+	t.Run("deleted when recording fails", func(t *testing.T) {
+		pb := newFakePrivateBin(t)
+		pbc := pb.client(t)
+		dc := newFakeHiveClient(t)
+
+		resp, err := pbc.CreatePaste(ctx, "password", "1day", "plaintext", false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pastes := []LabPaste{{ID: resp.ID, DeleteToken: resp.DeleteToken, Expire: "1day"}}
+		if err = salvageLabPastes(ctx, dc, pbc, testLabID, pastes, pasteErr); !errors.Is(err, ErrPasteFailed) {
+			t.Errorf("got %v, want the paste error", err)
+		}
+		if pb.count() != 0 {
+			t.Errorf("%d pastes left on the server", pb.count())
+		}
+	})
+}
+
+func newTestLabSecrets() kubernetes.Interface {
+	return k8sfake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "acme-admin-password", Namespace: "hive"},
+			Data:       map[string][]byte{"password": []byte("Xq7s-Vn2e-Lp9d-Rt4k")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "acme-admin-kubeconfig", Namespace: "hive"},
+			Data:       map[string][]byte{"kubeconfig": []byte("apiVersion: v1\nkind: Config\n")},
+		},
+	)
+}
+
+func TestGenerateLabPastes(t *testing.T) {
+	ctx := context.Background()
+	config := &Cfg{Expire: "1week", Formatter: "plaintext"}
+
+	pb := newFakePrivateBin(t)
+	pbc := pb.client(t)
+	dc := newFakeHiveClient(t, newTestClusterDeployment())
+	kc := newTestLabSecrets()
+
+	result, err := generateLabPastes(ctx, dc, kc, pbc, testLabID, config, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := pbc.GetPaste(ctx, result.PasswordURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Paste != "Xq7s-Vn2e-Lp9d-Rt4k" {
+		t.Errorf("password paste = %q", content.Paste)
+	}
+
+	if _, err = generateLabPastes(ctx, dc, kc, pbc, testLabID, config, false); !errors.Is(err, ErrPastesAlreadyIssued) {
+		t.Errorf("second issue: got %v, want ErrPastesAlreadyIssued", err)
+	}
+
+	if _, err = generateLabPastes(ctx, dc, kc, pbc, testLabID, config, true); err != nil {
+		t.Fatal(err)
+	}
+	if pb.count() != 2 {
+		t.Errorf("%d pastes on the server after reissue, want 2", pb.count())
+	}
+}
+
+func TestGenerateLabPastesPartialFailure(t *testing.T) {
+	ctx := context.Background()
+
+	pb := newFakePrivateBin(t)
+	pb.failPost(2)
+	pbc := pb.client(t)
+	dc := newFakeHiveClient(t, newTestClusterDeployment())
+
+	_, err := generateLabPastes(ctx, dc, newTestLabSecrets(), pbc, testLabID, &Cfg{Expire: "1day"}, false)
+	if !errors.Is(err, ErrPasteFailed) {
+		t.Fatalf("got %v, want ErrPasteFailed", err)
+	}
+
+	// the password paste was created and must stay revocable
+	if err = revokeLabPastes(ctx, dc, pbc, testLabID); err != nil {
+		t.Fatal(err)
+	}
+	if pb.count() != 0 {
+		t.Errorf("%d pastes left after revoking", pb.count())
+	}
+}
@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"net/http"
+	"sort"
+)
+
+const labReportDateLayout = "2006-01-02"
+
+// Placeholders maps the template placeholders of a lab report to their values.
+func (r *LabReport) Placeholders() map[string]string {
+	return map[string]string{
+		"{{company_name}}": r.CompanyName,
+		"{{cluster_name}}": r.ClusterName,
+		"{{console_url}}":  r.ConsoleURL,
+		"{{lease_start}}":  r.LeaseStart.Format(labReportDateLayout),
+		"{{lease_end}}":    r.LeaseEnd.Format(labReportDateLayout),
+		"{{sponsor}}":      r.Sponsor,
+	}
+}
+
+// GenerateLabReport copies the Google Doc templateID, replaces the placeholders with the
+// details of report, shares the copy read-only with the report contacts and returns its URL.
+// Extra client options, e.g. option.WithEndpoint, are passed to both the Drive and Docs services.
+func GenerateLabReport(ctx context.Context, client *http.Client, templateID string, report *LabReport,
+	opts ...option.ClientOption) (string, error) {
+	opts = append([]option.ClientOption{option.WithHTTPClient(client)}, opts...)
+
+	driveService, err := drive.NewService(ctx, opts...)
+	if err != nil {
+		return "", fmt.Errorf("cannot create drive service: %w", err)
+	}
+
+	docsService, err := docs.NewService(ctx, opts...)
+	if err != nil {
+		return "", fmt.Errorf("cannot create docs service: %w", err)
+	}
+
+	reportFile, err := driveService.Files.Copy(templateID, &drive.File{Name: report.ClusterName + " lab report"}).
+		SupportsAllDrives(true).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("cannot copy report template %s: %w", templateID, err)
+	}
+
+	placeholders := report.Placeholders()
+	keys := make([]string, 0, len(placeholders))
+	for placeholder := range placeholders {
+		keys = append(keys, placeholder)
+	}
+	sort.Strings(keys)
+
+	var requests []*docs.Request
+	for _, placeholder := range keys {
+		requests = append(requests, &docs.Request{
+			ReplaceAllText: &docs.ReplaceAllTextRequest{
+				ContainsText: &docs.SubstringMatchCriteria{Text: placeholder, MatchCase: true},
+				ReplaceText:  placeholders[placeholder],
+			},
+		})
+	}
+
+	_, err = docsService.Documents.BatchUpdate(reportFile.Id, &docs.BatchUpdateDocumentRequest{Requests: requests}).
+		Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("cannot fill report %s: %w", reportFile.Id, err)
+	}
+
+	for _, contact := range report.Contacts {
+		_, err = driveService.Permissions.Create(reportFile.Id, &drive.Permission{
+			Type:         "user",
+			Role:         "reader",
+			EmailAddress: contact,
+		}).SendNotificationEmail(true).SupportsAllDrives(true).Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("cannot share report %s with %s: %w", reportFile.Id, contact, err)
+		}
+	}
+
+	return "https://docs.google.com/document/d/" + reportFile.Id + "/edit", nil
+}
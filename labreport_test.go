@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/api/docs/v1"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/option"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGenerateLabReport(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		copied      drive.File
+		batch       docs.BatchUpdateDocumentRequest
+		permissions []drive.Permission
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files/template-id/copy", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := json.NewDecoder(r.Body).Decode(&copied); err != nil {
+			t.Errorf("copy body: %v", err)
+		}
+		json.NewEncoder(w).Encode(drive.File{Id: "report-id"})
+	})
+	mux.HandleFunc("/v1/documents/report-id:batchUpdate", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("batchUpdate body: %v", err)
+		}
+		json.NewEncoder(w).Encode(docs.BatchUpdateDocumentResponse{DocumentId: "report-id"})
+	})
+	mux.HandleFunc("/files/report-id/permissions", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var permission drive.Permission
+		if err := json.NewDecoder(r.Body).Decode(&permission); err != nil {
+			t.Errorf("permission body: %v", err)
+		}
+		if r.URL.Query().Get("sendNotificationEmail") != "true" {
+			t.Errorf("permission for %s is created without a notification", permission.EmailAddress)
+		}
+		permissions = append(permissions, permission)
+		json.NewEncoder(w).Encode(permission)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	report := &LabReport{
+		CompanyName: "ACME",
+		ClusterName: "acme-kr8noc",
+		ConsoleURL:  "https://console.example.com",
+		LeaseStart:  time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		LeaseEnd:    time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC),
+		Sponsor:     "Jane Doe",
+		Contacts:    []string{"primary@example.com", "secondary@example.com"},
+	}
+
+	url, err := GenerateLabReport(context.Background(), srv.Client(), "template-id", report,
+		option.WithEndpoint(srv.URL+"/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://docs.google.com/document/d/report-id/edit" {
+		t.Errorf("url = %q", url)
+	}
+
+	if copied.Name != "acme-kr8noc lab report" {
+		t.Errorf("copy name = %q", copied.Name)
+	}
+
+	replaced := make(map[string]string)
+	for _, request := range batch.Requests {
+		if request.ReplaceAllText == nil {
+			t.Fatalf("unexpected request %+v", request)
+		}
+		replaced[request.ReplaceAllText.ContainsText.Text] = request.ReplaceAllText.ReplaceText
+	}
+	for placeholder, value := range report.Placeholders() {
+		if replaced[placeholder] != value {
+			t.Errorf("%s replaced with %q, want %q", placeholder, replaced[placeholder], value)
+		}
+	}
+	if replaced["{{lease_end}}"] != "2026-10-15" {
+		t.Errorf("lease end = %q", replaced["{{lease_end}}"])
+	}
+
+	if len(permissions) != 2 {
+		t.Fatalf("got %d permissions, want 2", len(permissions))
+	}
+	for i, permission := range permissions {
+		if permission.EmailAddress != report.Contacts[i] || permission.Role != "reader" || permission.Type != "user" {
+			t.Errorf("unexpected permission %+v", permission)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	StateSubmitted    LabState = "submitted"
+	StateValidated    LabState = "validated"
+	StateApproved     LabState = "approved"
+	StateProvisioning LabState = "provisioning"
+	StateReady        LabState = "ready"
+	StateExpiring     LabState = "expiring"
+	StateHibernated   LabState = "hibernated"
+	StateReclaimed    LabState = "reclaimed"
+	StateRejected     LabState = "rejected"
+	StateFailed       LabState = "failed"
+)
+
+// labStateTransitions lists, for every state, the states a lab request may move to next.
+// A request without a state may only be submitted; rejected and reclaimed are terminal.
+var labStateTransitions = map[LabState][]LabState{
+	"":                {StateSubmitted},
+	StateSubmitted:    {StateValidated, StateRejected, StateFailed},
+	StateValidated:    {StateApproved, StateRejected},
+	StateApproved:     {StateProvisioning, StateRejected},
+	StateProvisioning: {StateReady, StateFailed},
+	StateReady:        {StateExpiring, StateHibernated, StateReclaimed},
+	StateExpiring:     {StateReady, StateHibernated, StateReclaimed},
+	StateHibernated:   {StateReady, StateExpiring, StateReclaimed},
+	StateFailed:       {StateProvisioning, StateReclaimed},
+	StateRejected:     {},
+	StateReclaimed:    {},
+}
+
+var (
+	stateHooksMu sync.RWMutex
+	stateHooks   []StateHook
+)
+
+// IllegalTransitionError is returned by Transition when the requested move is not allowed
+// from the current state of the lab request.
+type IllegalTransitionError struct {
+	From LabState
+	To   LabState
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("illegal lab state transition from %q to %q", e.From, e.To)
+}
+
+// CanTransition reports whether a lab request in state from may move to state to.
+func CanTransition(from, to LabState) bool {
+	for _, next := range labStateTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterStateHook adds a hook that is called, in registration order, after every
+// successful Transition.
+func RegisterStateHook(hook StateHook) {
+	stateHooksMu.Lock()
+	defer stateHooksMu.Unlock()
+	stateHooks = append(stateHooks, hook)
+}
+
+// Transition moves form to state to, records the transition and runs the registered hooks.
+// Illegal moves leave form untouched and return an *IllegalTransitionError. A failing hook
+// does not undo the transition or stop the remaining hooks; the first hook error is returned.
+func Transition(ctx context.Context, form *RequestForm, to LabState) error {
+	from := LabState(form.State)
+	if !CanTransition(from, to) {
+		return &IllegalTransitionError{From: from, To: to}
+	}
+
+	transition := StateTransition{From: from, To: to, At: time.Now().UTC()}
+	form.State = string(to)
+	form.UpdatedAt = transition.At
+	form.Transitions = append(form.Transitions, transition)
+
+	stateHooksMu.RLock()
+	hooks := make([]StateHook, len(stateHooks))
+	copy(hooks, stateHooks)
+	stateHooksMu.RUnlock()
+
+	var hookErr error
+	for _, hook := range hooks {
+		if err := hook(ctx, form, transition); err != nil && hookErr == nil {
+			hookErr = fmt.Errorf("state hook failed for lab %s (%s -> %s): %w", form.ID, from, to, err)
+		}
+	}
+
+	return hookErr
+}
+
+// Value stores the transition history as a JSON array.
+func (t StateTransitions) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+
+	data, err := json.Marshal(t)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal state transitions: %w", err)
+	}
+	return string(data), nil
+}
+
+// Scan loads the transition history from a JSON array. NULL, for forms stored before the
+// history was kept, is an empty history.
+func (t *StateTransitions) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unable to scan %T into state transitions", src)
+	}
+
+	if len(data) == 0 {
+		*t = nil
+		return nil
+	}
+
+	var transitions []StateTransition
+	if err := json.Unmarshal(data, &transitions); err != nil {
+		return fmt.Errorf("unable to unmarshal state transitions: %w", err)
+	}
+	*t = transitions
+	return nil
+}
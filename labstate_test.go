@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTransitionRecordsHistory(t *testing.T) {
+	form := &RequestForm{}
+	for _, to := range []LabState{StateSubmitted, StateValidated, StateApproved} {
+		if err := Transition(context.Background(), form, to); err != nil {
+			t.Fatalf("transition to %s: %v", to, err)
+		}
+	}
+
+	if form.State != string(StateApproved) {
+		t.Errorf("state = %q, want %q", form.State, StateApproved)
+	}
+	if len(form.Transitions) != 3 || form.Transitions[2].From != StateValidated {
+		t.Errorf("unexpected history %+v", form.Transitions)
+	}
+
+	var illegal *IllegalTransitionError
+	if err := Transition(context.Background(), form, StateReady); !errors.As(err, &illegal) {
+		t.Errorf("approved -> ready: got %v, want an IllegalTransitionError", err)
+	}
+}
+
+func TestStateTransitionsValueScan(t *testing.T) {
+	form := &RequestForm{}
+	if err := Transition(context.Background(), form, StateSubmitted); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := form.Transitions.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var loaded StateTransitions
+	if err = loaded.Scan([]byte(value.(string))); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0].To != StateSubmitted || !loaded[0].At.Equal(form.Transitions[0].At) {
+		t.Errorf("scanned %+v, want %+v", loaded, form.Transitions)
+	}
+
+	if err = loaded.Scan(nil); err != nil || loaded != nil {
+		t.Errorf("scanning NULL: %v, %+v", err, loaded)
+	}
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// LabBaseDomain is the base domain lab clusters are installed into.
+const LabBaseDomain = "opdev.io"
+
+func (z *StaticDNSZone) ClusterRecords(_ context.Context, clusterName, baseDomain string) ([]string, error) {
+	zone := strings.ToLower(clusterName + "." + baseDomain)
+
+	var records []string
+	for _, record := range z.Records {
+		record = strings.TrimSuffix(strings.ToLower(record), ".")
+		if record == zone || strings.HasSuffix(record, "."+zone) {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
+
+func (z *ResolverDNSZone) ClusterRecords(ctx context.Context, clusterName, baseDomain string) ([]string, error) {
+	resolver := z.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	zone := clusterName + "." + baseDomain
+	// the apps record is a wildcard, so any name below it resolves when it exists
+	names := []string{"api." + zone, "console-openshift-console.apps." + zone}
+
+	var records []string
+	for _, name := range names {
+		_, err := resolver.LookupHost(ctx, name)
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve %s: %w", name, err)
+		}
+		records = append(records, name)
+	}
+
+	return records, nil
+}
+
+// CheckClusterName checks that the cluster name of labRequest is not used by another
+// ClusterDeployment on the hub nor has records in zone. When it is taken, the first free
+// name built from a longer short ID is proposed as the alternative.
+func CheckClusterName(ctx context.Context, labRequest *LabRequest, zone DNSZone) (*ClusterNameCheck, error) {
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return checkClusterName(ctx, dc, zone, labRequest)
+}
+
+func checkClusterName(ctx context.Context, dc client.Client, zone DNSZone, labRequest *LabRequest) (*ClusterNameCheck, error) {
+	clusterName, err := LabClusterName(labRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	cdList := hivev1.ClusterDeploymentList{}
+	if err = dc.List(ctx, &cdList, client.InNamespace("hive")); err != nil {
+		return nil, fmt.Errorf("unable to list ClusterDeployments: %w", err)
+	}
+
+	inUse := make(map[string]string)
+	for _, cd := range cdList.Items {
+		if cd.Name != labRequest.ID.String() && cd.Spec.BaseDomain == LabBaseDomain {
+			inUse[cd.Spec.ClusterName] = cd.Name
+		}
+	}
+
+	conflicts, err := clusterNameConflicts(ctx, zone, inUse, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	check := &ClusterNameCheck{
+		ClusterName: clusterName,
+		Available:   len(conflicts) == 0,
+		Conflicts:   conflicts,
+	}
+	if check.Available {
+		return check, nil
+	}
+
+	candidate := *labRequest
+	for length := len(labShortID(labRequest)) + 1; length <= MaxShortIDLength; length++ {
+		candidate.ShortID = ShortLabID(labRequest.ID, length)
+
+		name, err := LabClusterName(&candidate)
+		if err != nil {
+			return nil, err
+		}
+
+		taken, err := clusterNameConflicts(ctx, zone, inUse, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(taken) == 0 {
+			check.Alternative = name
+			check.ShortID = candidate.ShortID
+			break
+		}
+	}
+
+	return check, nil
+}
+
+// clusterNameConflicts describes every hub ClusterDeployment and DNS record that uses name.
+func clusterNameConflicts(ctx context.Context, zone DNSZone, inUse map[string]string, name string) ([]string, error) {
+	var conflicts []string
+	if labID, ok := inUse[name]; ok {
+		conflicts = append(conflicts, "clusterdeployment hive/"+labID)
+	}
+
+	if zone != nil {
+		records, err := zone.ClusterRecords(ctx, name, LabBaseDomain)
+		if err != nil {
+			return nil, fmt.Errorf("unable to look up dns records of %s: %w", name, err)
+		}
+		for _, record := range records {
+			conflicts = append(conflicts, "dns record "+record)
+		}
+	}
+
+	return conflicts, nil
+}
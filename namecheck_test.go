@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"context"
+	"github.com/google/uuid"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+)
+
+// testClusterName is the cluster name of the "acme" test lab with a short ID of length.
+func testClusterName(length int) string {
+	return "acme-" + ShortLabID(uuid.MustParse(testLabID), length)
+}
+
+// newNamedClusterDeployment returns the ClusterDeployment of lab name installed as clusterName.
+func newNamedClusterDeployment(name, clusterName, baseDomain string) *hivev1.ClusterDeployment {
+	return &hivev1.ClusterDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "hive"},
+		Spec:       hivev1.ClusterDeploymentSpec{ClusterName: clusterName, BaseDomain: baseDomain},
+	}
+}
+
+func TestCheckClusterName(t *testing.T) {
+	labRequest := &LabRequest{ID: uuid.MustParse(testLabID), ClusterName: "acme"}
+
+	tests := map[string]struct {
+		objects     []client.Object
+		zone        DNSZone
+		conflicts   []string
+		alternative int
+	}{
+		"available": {
+			// the lab's own ClusterDeployment and clusters of other domains do not conflict
+			objects: []client.Object{
+				newNamedClusterDeployment(testLabID, testClusterName(6), LabBaseDomain),
+				newNamedClusterDeployment("other-lab", testClusterName(6), "example.com"),
+			},
+			zone: &StaticDNSZone{Records: []string{"api.acme.opdev.io"}},
+		},
+		"hub conflict": {
+			objects:     []client.Object{newNamedClusterDeployment("other-lab", testClusterName(6), LabBaseDomain)},
+			conflicts:   []string{"clusterdeployment hive/other-lab"},
+			alternative: 7,
+		},
+		"dns conflict": {
+			zone: &StaticDNSZone{Records: []string{
+				"API." + testClusterName(6) + ".opdev.io.",
+				"*.apps." + testClusterName(7) + ".opdev.io",
+			}},
+			conflicts:   []string{"dns record api." + testClusterName(6) + ".opdev.io"},
+			alternative: 8,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			check, err := checkClusterName(context.Background(), newFakeHiveClient(t, tt.objects...), tt.zone, labRequest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if check.ClusterName != testClusterName(6) || check.Available != (len(tt.conflicts) == 0) {
+				t.Errorf("got %+v", check)
+			}
+			if !reflect.DeepEqual(check.Conflicts, tt.conflicts) {
+				t.Errorf("got conflicts %q, want %q", check.Conflicts, tt.conflicts)
+			}
+
+			var alternative, shortID string
+			if tt.alternative > 0 {
+				alternative, shortID = testClusterName(tt.alternative), ShortLabID(labRequest.ID, tt.alternative)
+			}
+			if check.Alternative != alternative || check.ShortID != shortID {
+				t.Errorf("got alternative %q with short id %q, want %q with %q",
+					check.Alternative, check.ShortID, alternative, shortID)
+			}
+		})
+	}
+}
+
+func TestCheckClusterNameNoAlternative(t *testing.T) {
+	var objects []client.Object
+	for length := DefaultShortIDLength; length <= MaxShortIDLength; length++ {
+		objects = append(objects, newNamedClusterDeployment(uuid.NewString(), testClusterName(length), LabBaseDomain))
+	}
+
+	labRequest := &LabRequest{ID: uuid.MustParse(testLabID), ClusterName: "acme"}
+	check, err := checkClusterName(context.Background(), newFakeHiveClient(t, objects...), nil, labRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.Available || check.Alternative != "" || check.ShortID != "" {
+		t.Errorf("got %+v, want a conflict without alternative", check)
+	}
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// ShortIDLabel records the short ID of a lab on its ClusterDeployment.
+const ShortIDLabel = "opl-short-id"
+
+const (
+	// MaxClusterNameLength keeps cluster names short enough for the cloud resources the
+	// installer derives from them, such as AWS load balancer and target group names.
+	MaxClusterNameLength = 21
+
+	// DefaultShortIDLength is the length short IDs start at before growing on collisions.
+	DefaultShortIDLength = 6
+
+	// MaxShortIDLength is the longest short ID AssignShortID hands out.
+	MaxShortIDLength = 10
+)
+
+// ShortLabID returns the first length characters of the lowercased base58 encoding of id.
+// Lowercasing turns L into the l base58 leaves out because it reads as 1, so l is dropped.
+func ShortLabID(id uuid.UUID, length int) string {
+	encoded := strings.ReplaceAll(strings.ToLower(Encode(id[:])), "l", "")
+	if length > 0 && length < len(encoded) {
+		encoded = encoded[:length]
+	}
+	return encoded
+}
+
+// labShortID returns the assigned short ID of labRequest, or the default length one derived
+// from its ID when none has been assigned.
+func labShortID(labRequest *LabRequest) string {
+	if labRequest.ShortID != "" {
+		return labRequest.ShortID
+	}
+	return ShortLabID(labRequest.ID, DefaultShortIDLength)
+}
+
+// LabClusterName builds the cluster name of labRequest from its requested cluster name and
+// short ID. The requested name is normalised and truncated so the result is a DNS-1035 label
+// of at most MaxClusterNameLength characters.
+func LabClusterName(labRequest *LabRequest) (string, error) {
+	shortID := labShortID(labRequest)
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(labRequest.ClusterName) {
+		// names must start with a letter; any other character becomes a single dash
+		switch {
+		case r >= 'a' && r <= 'z':
+			b.WriteRune(r)
+		case b.Len() == 0:
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case !strings.HasSuffix(b.String(), "-"):
+			b.WriteRune('-')
+		}
+	}
+
+	prefix := b.String()
+	if limit := MaxClusterNameLength - len(shortID) - 1; len(prefix) > limit {
+		if limit < 1 {
+			return "", fmt.Errorf("short id %q leaves no room for a cluster name", shortID)
+		}
+		prefix = prefix[:limit]
+	}
+	prefix = strings.TrimRight(prefix, "-")
+	if prefix == "" {
+		prefix = "lab"
+	}
+
+	name := prefix + "-" + shortID
+	if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+		return "", fmt.Errorf("invalid cluster name %q: %s", name, strings.Join(errs, "; "))
+	}
+
+	return name, nil
+}
+
+// AssignShortID sets the ShortID of labRequest to the shortest prefix, starting at
+// DefaultShortIDLength, that no other lab on the hub uses.
+func AssignShortID(ctx context.Context, labRequest *LabRequest) error {
+	dc, err := hiveClient()
+	if err != nil {
+		return err
+	}
+
+	return assignShortID(ctx, dc, labRequest)
+}
+
+func assignShortID(ctx context.Context, dc client.Client, labRequest *LabRequest) error {
+	cdList := hivev1.ClusterDeploymentList{}
+	if err := dc.List(ctx, &cdList, client.InNamespace("hive"), client.HasLabels{ShortIDLabel}); err != nil {
+		return fmt.Errorf("unable to list ClusterDeployments: %w", err)
+	}
+
+	taken := make(map[string]bool)
+	for _, cd := range cdList.Items {
+		if cd.Name != labRequest.ID.String() {
+			taken[cd.Labels[ShortIDLabel]] = true
+		}
+	}
+
+	for length := DefaultShortIDLength; length <= MaxShortIDLength; length++ {
+		shortID := ShortLabID(labRequest.ID, length)
+		if !taken[shortID] {
+			labRequest.ShortID = shortID
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unable to find a free short id for lab %s", labRequest.ID)
+}
+
+// LookupLabID maps the short ID of a lab back to its full lab ID.
+func LookupLabID(ctx context.Context, shortID string) (uuid.UUID, error) {
+	dc, err := hiveClient()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return lookupLabID(ctx, dc, shortID)
+}
+
+func lookupLabID(ctx context.Context, dc client.Client, shortID string) (uuid.UUID, error) {
+	cdList := hivev1.ClusterDeploymentList{}
+	err := dc.List(ctx, &cdList, client.InNamespace("hive"),
+		client.MatchingLabels{ShortIDLabel: strings.ToLower(shortID)})
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("unable to list ClusterDeployments: %w", err)
+	}
+
+	switch len(cdList.Items) {
+	case 0:
+		return uuid.Nil, fmt.Errorf("%w: %s", ErrLabNotFound, shortID)
+	case 1:
+		return uuid.Parse(cdList.Items[0].Name)
+	default:
+		return uuid.Nil, fmt.Errorf("short id %s matches %d labs", shortID, len(cdList.Items))
+	}
+}
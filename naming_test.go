@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+	"testing"
+)
+
+// newShortIDClusterDeployment returns the ClusterDeployment of lab name labelled with shortID.
+func newShortIDClusterDeployment(name, shortID string) *hivev1.ClusterDeployment {
+	return &hivev1.ClusterDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "hive", Labels: map[string]string{ShortIDLabel: shortID}},
+	}
+}
+
+func TestShortLabID(t *testing.T) {
+	// encodes to h1LxKPjZAPRKmCscuXiqn
+	id := uuid.MustParse("0595f67b-1cc8-45e7-b134-a0cde937dea1")
+
+	if shortID := ShortLabID(id, 6); shortID != "h1xkpj" {
+		t.Errorf("got %q, want h1xkpj", shortID)
+	}
+	if shortID := ShortLabID(id, 10); !strings.HasPrefix(shortID, ShortLabID(id, 6)) || len(shortID) != 10 {
+		t.Errorf("got %q, want a 10 character extension of the 6 character short id", shortID)
+	}
+	if shortID := ShortLabID(id, 0); shortID != "h1xkpjzaprkmcscuxiqn" {
+		t.Errorf("got %q for the full length", shortID)
+	}
+}
+
+func TestLabClusterName(t *testing.T) {
+	tests := map[string]struct {
+		clusterName string
+		shortID     string
+		name        string
+	}{
+		"plain":          {clusterName: "acme", shortID: "kr8noc", name: "acme-kr8noc"},
+		"normalised":     {clusterName: "ACME Corp., Inc.", shortID: "kr8noc", name: "acme-corp-inc-kr8noc"},
+		"leading digits": {clusterName: "42 Labs", shortID: "kr8noc", name: "labs-kr8noc"},
+		"no letters":     {clusterName: "2021!", shortID: "kr8noc", name: "lab-kr8noc"},
+		"truncated":      {clusterName: "acme-partner-enablement", shortID: "kr8noc", name: "acme-partner-e-kr8noc"},
+		"trailing dash":  {clusterName: "acme partner labs", shortID: "kr8nocx", name: "acme-partner-kr8nocx"},
+		"default short":  {clusterName: "acme", name: "acme-" + ShortLabID(uuid.MustParse(testLabID), DefaultShortIDLength)},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			labRequest := &LabRequest{ID: uuid.MustParse(testLabID), ClusterName: tt.clusterName, ShortID: tt.shortID}
+
+			clusterName, err := LabClusterName(labRequest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if clusterName != tt.name {
+				t.Errorf("got %q, want %q", clusterName, tt.name)
+			}
+			if len(clusterName) > MaxClusterNameLength {
+				t.Errorf("%q is longer than %d characters", clusterName, MaxClusterNameLength)
+			}
+			if errs := validation.IsDNS1035Label(clusterName); len(errs) > 0 {
+				t.Errorf("%q is not a DNS-1035 label: %v", clusterName, errs)
+			}
+		})
+	}
+
+	labRequest := &LabRequest{ID: uuid.MustParse(testLabID), ClusterName: "acme", ShortID: strings.Repeat("x", MaxClusterNameLength-1)}
+	if _, err := LabClusterName(labRequest); err == nil {
+		t.Error("short id without room for a cluster name accepted")
+	}
+}
+
+func TestAssignShortID(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.MustParse(testLabID)
+
+	// the lab's own ClusterDeployment does not count as a collision
+	dc := newFakeHiveClient(t,
+		newShortIDClusterDeployment(testLabID, ShortLabID(id, 9)),
+		newShortIDClusterDeployment("other-lab-a", ShortLabID(id, DefaultShortIDLength)),
+		newShortIDClusterDeployment("other-lab-b", ShortLabID(id, DefaultShortIDLength+1)),
+	)
+
+	labRequest := &LabRequest{ID: id}
+	if err := assignShortID(ctx, dc, labRequest); err != nil {
+		t.Fatal(err)
+	}
+	if want := ShortLabID(id, DefaultShortIDLength+2); labRequest.ShortID != want {
+		t.Errorf("got short id %q, want %q", labRequest.ShortID, want)
+	}
+
+	var taken []client.Object
+	for length := DefaultShortIDLength; length <= MaxShortIDLength; length++ {
+		taken = append(taken, newShortIDClusterDeployment(uuid.NewString(), ShortLabID(id, length)))
+	}
+	if err := assignShortID(ctx, newFakeHiveClient(t, taken...), &LabRequest{ID: id}); err == nil {
+		t.Error("short id assigned although every length is taken")
+	}
+}
+
+func TestLookupLabID(t *testing.T) {
+	ctx := context.Background()
+	dc := newFakeHiveClient(t,
+		newShortIDClusterDeployment(testLabID, "kr8noc"),
+		newShortIDClusterDeployment(uuid.NewString(), "tw1n5x"),
+		newShortIDClusterDeployment(uuid.NewString(), "tw1n5x"),
+	)
+
+	labID, err := lookupLabID(ctx, dc, "KR8NOC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labID.String() != testLabID {
+		t.Errorf("got lab %s, want %s", labID, testLabID)
+	}
+
+	if _, err = lookupLabID(ctx, dc, "zzzzzz"); !errors.Is(err, ErrLabNotFound) {
+		t.Errorf("unknown short id: got %v, want ErrLabNotFound", err)
+	}
+	if _, err = lookupLabID(ctx, dc, "tw1n5x"); err == nil || !strings.Contains(err.Error(), "matches 2 labs") {
+		t.Errorf("ambiguous short id: got %v", err)
+	}
+}
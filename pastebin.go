@@ -2,9 +2,11 @@ package utils
 
 import (
 	"bytes"
+	"compress/flate"
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
@@ -13,11 +15,10 @@ import (
 	"io"
 	"io/ioutil"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"log"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -34,22 +35,64 @@ func (ps *PasteSpec) SpecArray() []interface{} {
 	}
 }
 
+// NewPBClient creates a PrivateBin client whose requests time out after the DefaultHTTPTimeout
+// in effect when it is created.
+// Replace HTTPClient to use a different timeout or transport.
 func NewPBClient(uri *url.URL, username, password string) *PBClient {
-	return &PBClient{URL: *uri, Username: username, Password: password}
+	return &PBClient{
+		URL:        *uri,
+		Username:   username,
+		Password:   password,
+		HTTPClient: &http.Client{Timeout: DefaultHTTPTimeout},
+	}
+}
+
+func (c *PBClient) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: DefaultHTTPTimeout}
+}
+
+func (c *PBClient) CreatePaste(ctx context.Context, message, expire, formatter string, openDiscussion, burnAfterReading bool) (*CreatePasteResponse, error) {
+	return c.createPaste(ctx, &PasteContent{Paste: message}, "", expire, formatter, openDiscussion, burnAfterReading)
+}
+
+// CreatePasteWithPassword creates a paste that can only be decrypted with both the key in the
+// returned URL and password, so the URL alone does not reveal the paste.
+func (c *PBClient) CreatePasteWithPassword(ctx context.Context, message, password, expire, formatter string,
+	openDiscussion, burnAfterReading bool) (*CreatePasteResponse, error) {
+	if password == "" {
+		return nil, fmt.Errorf("paste password must not be empty")
+	}
+	return c.createPaste(ctx, &PasteContent{Paste: message}, password, expire, formatter, openDiscussion, burnAfterReading)
+}
+
+// CreatePasteWithAttachments creates a paste holding message and attachments. password may be
+// empty for pastes that only need the key in the returned URL.
+func (c *PBClient) CreatePasteWithAttachments(ctx context.Context, message string, attachments []PasteAttachment,
+	password, expire, formatter string, openDiscussion, burnAfterReading bool) (*CreatePasteResponse, error) {
+	content := &PasteContent{Paste: message}
+	for _, attachment := range attachments {
+		content.AddAttachment(attachment)
+	}
+	return c.createPaste(ctx, content, password, expire, formatter, openDiscussion, burnAfterReading)
 }
 
-func (c *PBClient) CreatePaste(message, expire, formatter string, openDiscussion, burnAfterReading bool) (*CreatePasteResponse, error) {
-	masterKey, err := generateRandomBytes(32)
+func (c *PBClient) createPaste(ctx context.Context, content *PasteContent, password, expire, formatter string,
+	openDiscussion, burnAfterReading bool) (*CreatePasteResponse, error) {
+	masterKey, err := generateRandomBytes(c.random(), 32)
 	if err != nil {
 		return nil, fmt.Errorf("cannot generate random bytes: %w", err)
 	}
 
-	pasteContent, err := json.Marshal(&PasteContent{Paste: message})
+	pasteContent, err := json.Marshal(content)
 	if err != nil {
 		return nil, fmt.Errorf("cannot marshal paste content: %w", err)
 	}
 
-	pasteData, err := encrypt(masterKey, pasteContent, formatter, openDiscussion, burnAfterReading)
+	pasteData, err := encrypt(c.random(), masterKey, password, pasteContent, c.Compression, formatter, openDiscussion,
+		burnAfterReading)
 	if err != nil {
 		return nil, fmt.Errorf("cannot encrypt data: %w", err)
 	}
@@ -66,17 +109,16 @@ func (c *PBClient) CreatePaste(message, expire, formatter string, openDiscussion
 		return nil, fmt.Errorf("cannot marshal paste request: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", c.URL.String(), bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL.String(), bytes.NewBuffer(body))
 	if err != nil {
-		fmt.Printf("unable to create new request: %v", err)
+		return nil, fmt.Errorf("cannot create http request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
 	req.Header.Set("X-Requested-With", "JSONHttpRequest")
 	req.SetBasicAuth(c.Username, c.Password)
 
-	client := &http.Client{}
-	res, err := client.Do(req)
+	res, err := c.httpClient().Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("cannot execute http request: %w", err)
 	}
@@ -122,15 +164,306 @@ func (c *PBClient) CreatePaste(message, expire, formatter string, openDiscussion
 	return &pasteResponse, nil
 }
 
-func generateRandomBytes(n uint32) ([]byte, error) {
-	rand.Seed(time.Now().UnixNano())
+// GetPaste fetches the paste behind pasteURL, as returned by CreatePaste, from the PrivateBin
+// server of the client and decrypts it with the master key carried in the URL fragment.
+func (c *PBClient) GetPaste(ctx context.Context, pasteURL string) (*PasteContent, error) {
+	return c.GetPasteWithPassword(ctx, pasteURL, "")
+}
+
+// GetPasteWithPassword is GetPaste for pastes created with CreatePasteWithPassword.
+func (c *PBClient) GetPasteWithPassword(ctx context.Context, pasteURL, password string) (*PasteContent, error) {
+	pasteID, masterKey, err := parsePasteURL(pasteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	uri := c.URL
+	uri.RawQuery = pasteID
+	uri.Fragment = ""
+
+	req, err := http.NewRequestWithContext(ctx, "GET", uri.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create http request: %w", err)
+	}
+	req.Header.Set("X-Requested-With", "JSONHttpRequest")
+	req.SetBasicAuth(c.Username, c.Password)
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("cannot execute http request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			fmt.Printf("unable to close connection: %v", err)
+		}
+	}(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pastebin server responds with %q status code", res.Status)
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read response body: %w", err)
+	}
+
+	pasteResponse := GetPasteResponse{}
+	err = json.Unmarshal(resBody, &pasteResponse)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal response: %w", err)
+	}
+
+	if pasteResponse.Status != 0 {
+		if strings.Contains(pasteResponse.Message, "does not exist") {
+			return nil, fmt.Errorf("%w: %s", ErrPasteNotFound, pasteID)
+		}
+		return nil, fmt.Errorf("status of the paste is not zero: %s", pasteResponse.Message)
+	}
+
+	if pasteResponse.V != 2 {
+		return nil, fmt.Errorf("unsupported paste format version %d", pasteResponse.V)
+	}
+
+	ct, err := decodePasteBase64(pasteResponse.CT)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode paste cipher text: %w", err)
+	}
+
+	pasteContent, err := decrypt(masterKey, password, pasteResponse.AData, ct)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt data: %w", err)
+	}
+
+	content := PasteContent{}
+	err = json.Unmarshal(pasteContent, &content)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal paste content: %w", err)
+	}
+
+	return &content, nil
+}
+
+// DeletePaste removes paste id from the PrivateBin server using the delete token returned
+// when the paste was created.
+func (c *PBClient) DeletePaste(ctx context.Context, id, deleteToken string) error {
+	body, err := json.Marshal(&DeletePasteRequest{PasteID: id, DeleteToken: deleteToken})
+	if err != nil {
+		return fmt.Errorf("cannot marshal delete request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL.String(), bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("cannot create http request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set("X-Requested-With", "JSONHttpRequest")
+	req.SetBasicAuth(c.Username, c.Password)
+
+	res, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot execute http request: %w", err)
+	}
+	defer func(Body io.ReadCloser) {
+		err := Body.Close()
+		if err != nil {
+			fmt.Printf("unable to close connection: %v", err)
+		}
+	}(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("pastebin server responds with %q status code", res.Status)
+	}
+
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("cannot read response body: %w", err)
+	}
+
+	deleteResponse := CreatePasteResponse{}
+	err = json.Unmarshal(resBody, &deleteResponse)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal response: %w", err)
+	}
+
+	if deleteResponse.Status != 0 {
+		if strings.Contains(deleteResponse.Message, "does not exist") {
+			return fmt.Errorf("%w: %s", ErrPasteNotFound, id)
+		}
+		return fmt.Errorf("status of the paste deletion is not zero: %s", deleteResponse.Message)
+	}
+
+	return nil
+}
+
+// parsePasteURL extracts the paste ID from the query and the base58 master key from the
+// fragment of a paste URL.
+func parsePasteURL(pasteURL string) (string, []byte, error) {
+	uri, err := url.Parse(pasteURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot parse paste url: %w", err)
+	}
+
+	pasteID := uri.RawQuery
+	if values, err := url.ParseQuery(uri.RawQuery); err == nil && values.Get("pasteid") != "" {
+		pasteID = values.Get("pasteid")
+	}
+	if pasteID == "" {
+		return "", nil, fmt.Errorf("paste url %q has no paste id", pasteURL)
+	}
+
+	// PrivateBin prefixes the key with "-" when the paste asks for confirmation before loading
+	fragment := strings.TrimPrefix(uri.Fragment, "-")
+	if fragment == "" {
+		return "", nil, fmt.Errorf("paste url %q has no key", pasteURL)
+	}
+
+	masterKey, err := Decode(fragment)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot decode paste key: %w", err)
+	}
+
+	return pasteID, masterKey, nil
+}
+
+// decodePasteBase64 decodes base64 with or without padding; PrivateBin's web client pads,
+// this package does not.
+func decodePasteBase64(s string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// random returns the source of the master key, IV and salt of new pastes.
+func (c *PBClient) random() io.Reader {
+	if c.Rand != nil {
+		return c.Rand
+	}
+	return rand.Reader
+}
+
+func generateRandomBytes(r io.Reader, n uint32) ([]byte, error) {
 	b := make([]byte, n)
-	if _, err := rand.Read(b); err != nil {
+	if _, err := io.ReadFull(r, b); err != nil {
 		return nil, err
 	}
 	return b, nil
 }
 
+// AddAttachment attaches a file to the paste as a base64 data URI.
+func (p *PasteContent) AddAttachment(attachment PasteAttachment) {
+	mediaType := attachment.MediaType
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+
+	p.Attachment = append(p.Attachment,
+		"data:"+mediaType+";base64,"+base64.StdEncoding.EncodeToString(attachment.Data))
+	p.AttachmentName = append(p.AttachmentName, attachment.Name)
+}
+
+// Attachments decodes the data URIs attached to the paste.
+func (p *PasteContent) Attachments() ([]PasteAttachment, error) {
+	var attachments []PasteAttachment
+	for i, dataURI := range p.Attachment {
+		attachment := PasteAttachment{}
+		if i < len(p.AttachmentName) {
+			attachment.Name = p.AttachmentName[i]
+		}
+
+		if !strings.HasPrefix(dataURI, "data:") {
+			return nil, fmt.Errorf("attachment %q is not a data uri", attachment.Name)
+		}
+		comma := strings.Index(dataURI, ",")
+		if comma < 0 {
+			return nil, fmt.Errorf("attachment %q is a malformed data uri", attachment.Name)
+		}
+
+		header, data := dataURI[len("data:"):comma], dataURI[comma+1:]
+		if strings.HasSuffix(header, ";base64") {
+			attachment.MediaType = strings.TrimSuffix(header, ";base64")
+			decoded, err := decodePasteBase64(data)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode attachment %q: %w", attachment.Name, err)
+			}
+			attachment.Data = decoded
+		} else {
+			attachment.MediaType = header
+			decoded, err := url.PathUnescape(data)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode attachment %q: %w", attachment.Name, err)
+			}
+			attachment.Data = []byte(decoded)
+		}
+
+		attachments = append(attachments, attachment)
+	}
+
+	return attachments, nil
+}
+
+func (p PasteContent) MarshalJSON() ([]byte, error) {
+	content := struct {
+		Paste          string      `json:"paste"`
+		Attachment     interface{} `json:"attachment,omitempty"`
+		AttachmentName interface{} `json:"attachment_name,omitempty"`
+	}{Paste: p.Paste}
+
+	switch len(p.Attachment) {
+	case 0:
+	case 1:
+		content.Attachment = p.Attachment[0]
+		if len(p.AttachmentName) > 0 {
+			content.AttachmentName = p.AttachmentName[0]
+		}
+	default:
+		content.Attachment = p.Attachment
+		content.AttachmentName = p.AttachmentName
+	}
+
+	return json.Marshal(content)
+}
+
+func (p *PasteContent) UnmarshalJSON(data []byte) error {
+	content := struct {
+		Paste          string          `json:"paste"`
+		Attachment     json.RawMessage `json:"attachment"`
+		AttachmentName json.RawMessage `json:"attachment_name"`
+	}{}
+	if err := json.Unmarshal(data, &content); err != nil {
+		return err
+	}
+
+	attachment, err := stringOrStrings(content.Attachment)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal attachment: %w", err)
+	}
+
+	attachmentName, err := stringOrStrings(content.AttachmentName)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal attachment name: %w", err)
+	}
+
+	*p = PasteContent{Paste: content.Paste, Attachment: attachment, AttachmentName: attachmentName}
+	return nil
+}
+
+func stringOrStrings(data json.RawMessage) ([]string, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		return []string{one}, nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return nil, err
+	}
+	return many, nil
+}
+
 func (p *PasteData) adata() []interface{} {
 	var b2i = map[bool]int8{false: 0, true: 1}
 
@@ -142,13 +475,23 @@ func (p *PasteData) adata() []interface{} {
 	}
 }
 
-func encrypt(masterKey []byte, message []byte, formatter string, openDiscussion, burnAfterReading bool) (*PasteData, error) {
-	iv, err := generateRandomBytes(12)
+func encrypt(r io.Reader, masterKey []byte, password string, message []byte, compression, formatter string, openDiscussion,
+	burnAfterReading bool) (*PasteData, error) {
+	if compression == "" {
+		compression = "none"
+	}
+
+	message, err := compress(message, compression)
+	if err != nil {
+		return nil, err
+	}
+
+	iv, err := generateRandomBytes(r, 12)
 	if err != nil {
 		return nil, err
 	}
 
-	salt, err := generateRandomBytes(8)
+	salt, err := generateRandomBytes(r, 8)
 	if err != nil {
 		return nil, err
 	}
@@ -165,89 +508,287 @@ func encrypt(masterKey []byte, message []byte, formatter string, openDiscussion,
 			TagSize:     128,
 			Algorithm:   "aes",
 			Mode:        "gcm",
-			Compression: "none",
+			Compression: compression,
 		},
 	}
 
-	key := pbkdf2.Key(masterKey, salt, paste.Iterations, 32, sha256.New)
+	key := pbkdf2.Key(keyMaterial(masterKey, password), salt, paste.Iterations, 32, sha256.New)
 
 	adata, err := json.Marshal(paste.adata())
 	if err != nil {
 		return nil, err
 	}
 
-	c, err := aes.NewCipher(key)
+	gcm, err := newPasteGCM(key, len(iv), paste.TagSize)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(c)
+	data := gcm.Seal(nil, iv, message, adata)
+
+	paste.Data = data
+
+	return paste, nil
+}
+
+// keyMaterial returns the input of the key derivation. Like the PrivateBin web client does for
+// v2 pastes, a password is mixed in by appending its bytes to the master key. Only v1 pastes,
+// which this package does not read, appended the hex encoded SHA-256 of the password instead.
+func keyMaterial(masterKey []byte, password string) []byte {
+	if password == "" {
+		return masterKey
+	}
+
+	material := make([]byte, 0, len(masterKey)+len(password))
+	material = append(material, masterKey...)
+	return append(material, password...)
+}
+
+// decrypt opens the cipher text ct of a paste using the parameters stored in its adata,
+// which is also the additional authenticated data of the cipher.
+func decrypt(masterKey []byte, password string, rawAData json.RawMessage, ct []byte) ([]byte, error) {
+	paste, adata, err := parseAData(rawAData)
 	if err != nil {
 		return nil, err
 	}
 
-	data := gcm.Seal(nil, iv, message, adata)
+	if paste.Algorithm != "aes" || paste.Mode != "gcm" {
+		return nil, fmt.Errorf("unsupported cipher %s-%s", paste.Algorithm, paste.Mode)
+	}
 
-	paste.Data = data
+	if paste.KeySize%8 != 0 || paste.KeySize <= 0 {
+		return nil, fmt.Errorf("invalid key size %d", paste.KeySize)
+	}
 
-	return paste, nil
+	iv, err := decodePasteBase64(paste.IV)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode iv: %w", err)
+	}
+
+	salt, err := decodePasteBase64(paste.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode salt: %w", err)
+	}
+
+	key := pbkdf2.Key(keyMaterial(masterKey, password), salt, paste.Iterations, paste.KeySize/8, sha256.New)
+
+	gcm, err := newPasteGCM(key, len(iv), paste.TagSize)
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := gcm.Open(nil, iv, ct, adata)
+	if err != nil {
+		return nil, err
+	}
+
+	return decompress(message, paste.Compression)
 }
 
-func GeneratePrivateBinPaste(labs map[string]interface{}) map[string][]string {
-	var labIds []string
-	for _, info := range labs {
-		labId := info.(map[string]interface{})["details"].([]string)[0]
-		labIds = append(labIds, labId)
+// compress deflates message for the "zlib" compression of PrivateBin, which despite its
+// name is a raw deflate stream without zlib header or checksum.
+func compress(message []byte, compression string) ([]byte, error) {
+	switch compression {
+	case "none":
+		return message, nil
+	case "zlib":
+		var buf bytes.Buffer
+		w, err := flate.NewWriter(&buf, flate.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = w.Write(message); err != nil {
+			return nil, fmt.Errorf("cannot compress paste: %w", err)
+		}
+		if err = w.Close(); err != nil {
+			return nil, fmt.Errorf("cannot compress paste: %w", err)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
 	}
+}
 
-	labIdWithPastes := make(map[string][]string)
+func decompress(message []byte, compression string) ([]byte, error) {
+	switch compression {
+	case "none":
+		return message, nil
+	case "zlib":
+		r := flate.NewReader(bytes.NewReader(message))
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decompress paste: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", compression)
+	}
+}
+
+// parseAData reads the paste parameters from the adata of a paste and returns them along
+// with the adata serialized the way it was authenticated at creation time.
+func parseAData(rawAData json.RawMessage) (*PasteData, []byte, error) {
+	var adata []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(rawAData))
+	decoder.UseNumber()
+	if err := decoder.Decode(&adata); err != nil {
+		return nil, nil, fmt.Errorf("cannot unmarshal adata: %w", err)
+	}
+
+	if len(adata) != 4 {
+		return nil, nil, fmt.Errorf("adata has %d elements, expected 4", len(adata))
+	}
+
+	spec, ok := adata[0].([]interface{})
+	if !ok || len(spec) != 8 {
+		return nil, nil, fmt.Errorf("adata has a malformed cipher spec")
+	}
+
+	var (
+		strs = make([]string, 0, 5)
+		ints = make([]int, 0, 3)
+	)
+	for i, v := range spec {
+		switch i {
+		case 2, 3, 4:
+			n, ok := v.(json.Number)
+			if !ok {
+				return nil, nil, fmt.Errorf("adata cipher spec element %d is not a number", i)
+			}
+			i64, err := n.Int64()
+			if err != nil {
+				return nil, nil, fmt.Errorf("adata cipher spec element %d: %w", i, err)
+			}
+			ints = append(ints, int(i64))
+		default:
+			s, ok := v.(string)
+			if !ok {
+				return nil, nil, fmt.Errorf("adata cipher spec element %d is not a string", i)
+			}
+			strs = append(strs, s)
+		}
+	}
+
+	formatter, _ := adata[1].(string)
+	openDiscussion, _ := adata[2].(json.Number)
+	burnAfterReading, _ := adata[3].(json.Number)
+
+	paste := &PasteData{
+		Formatter:        formatter,
+		OpenDiscussion:   openDiscussion == "1",
+		BurnAfterReading: burnAfterReading == "1",
+		PasteSpec: &PasteSpec{
+			IV:          strs[0],
+			Salt:        strs[1],
+			Iterations:  ints[0],
+			KeySize:     ints[1],
+			TagSize:     ints[2],
+			Algorithm:   strs[2],
+			Mode:        strs[3],
+			Compression: strs[4],
+		},
+	}
+
+	authenticated, err := json.Marshal(adata)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot marshal adata: %w", err)
+	}
+
+	return paste, authenticated, nil
+}
 
-	kc := K8sAuthenticate()
+// newPasteGCM creates an AES-GCM cipher for key with the given nonce length and tag size in bits.
+func newPasteGCM(key []byte, nonceSize, tagSize int) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
 
-	config := Cfg{
-		Name:             "default",
-		Host:             "https://bin.apps.eng.partner-lab.rhecoeng.com",
-		Username:         "dev",
-		Password:         "dev",
-		Expire:           "5min",
-		OpenDiscussion:   false,
-		BurnAfterReading: true,
-		Formatter:        "plaintext",
+	switch {
+	case tagSize == 128:
+		return cipher.NewGCMWithNonceSize(c, nonceSize)
+	case nonceSize == 12:
+		return cipher.NewGCMWithTagSize(c, tagSize/8)
+	default:
+		return nil, fmt.Errorf("unsupported combination of %d byte iv and %d bit tag", nonceSize, tagSize)
 	}
+}
 
-	uri, err := url.Parse(config.Host)
+// GeneratePrivateBinPaste pastes the credentials of every lab in labs. Labs that have not
+// finished installing have no credentials yet and are skipped.
+//
+// Deprecated: use GenerateLabPastes to paste the credentials of a single lab on request.
+func GeneratePrivateBinPaste(ctx context.Context, labs map[string]interface{}, config *Cfg) (map[string][]string, error) {
+	labIdWithPastes := make(map[string][]string)
+
+	kc, err := K8sAuthenticate()
 	if err != nil {
-		log.Printf("Cannot parse %q bin host: %v", config.Name, config.Host)
+		return nil, err
 	}
 
-	pbc := NewPBClient(uri, config.Username, config.Password)
+	pbc, err := NewPBClientFromCfg(config)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
 
 	for _, info := range labs {
 		var pasteData []string
 
-		adminPasswordSecretRef, err := kc.CoreV1().Secrets("hive").Get(context.Background(),
-			info.(map[string]interface{})["details"].([]string)[2], metav1.GetOptions{})
-		ErrorCheck("Unable to get admin password secret reference: ", err)
+		details := info.(map[string]interface{})["details"].([]string)
+		labId := details[0]
 
-		kubeConfigSecretRef, err := kc.CoreV1().Secrets("hive").Get(context.Background(),
-			info.(map[string]interface{})["details"].([]string)[3], metav1.GetOptions{})
-		ErrorCheck("Unable to get kubeconfig secret reference: ", err)
+		if details[2] == "" || details[3] == "" {
+			continue
+		}
+
+		adminPasswordSecretRef, err := kc.CoreV1().Secrets("hive").Get(ctx,
+			details[2], metav1.GetOptions{})
+		if err != nil {
+			return labIdWithPastes, &PasteError{LabID: labId,
+				Err: fmt.Errorf("unable to get admin password secret reference: %w", err)}
+		}
+
+		kubeConfigSecretRef, err := kc.CoreV1().Secrets("hive").Get(ctx,
+			details[3], metav1.GetOptions{})
+		if err != nil {
+			return labIdWithPastes, &PasteError{LabID: labId,
+				Err: fmt.Errorf("unable to get kubeconfig secret reference: %w", err)}
+		}
 
 		pasteData = append(pasteData, string(adminPasswordSecretRef.Data["password"]),
 			string(kubeConfigSecretRef.Data["kubeconfig"]))
 
+		var labPastes []LabPaste
 		for _, paste := range pasteData {
 			resp, err := pbc.CreatePaste(
+				ctx,
 				paste,
 				config.Expire,
 				config.Formatter,
 				config.OpenDiscussion,
 				config.BurnAfterReading)
-			ErrorCheck("Unable to create paste: %v", err)
-			labIdWithPastes[info.(map[string]interface{})["details"].([]string)[0]] = append(
-				labIdWithPastes[info.(map[string]interface{})["details"].([]string)[0]], resp.URL)
+			if err != nil {
+				return labIdWithPastes, salvageLabPastes(ctx, dc, pbc, labId, labPastes, &PasteError{LabID: labId, Err: err})
+			}
+			labIdWithPastes[labId] = append(labIdWithPastes[labId], resp.URL)
+			labPastes = append(labPastes, LabPaste{
+				ID:          resp.ID,
+				DeleteToken: resp.DeleteToken,
+				Expire:      config.Expire,
+				CreatedAt:   time.Now().UTC(),
+			})
+		}
+
+		if err = recordLabPastes(ctx, dc, labId, labPastes); err != nil {
+			return labIdWithPastes, &PasteError{LabID: labId, Err: err}
 		}
 	}
 
-	return labIdWithPastes
+	return labIdWithPastes, nil
 }
@@ -0,0 +1,381 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// fakePrivateBin is a PrivateBin stand-in that stores pastes as they are posted, the way the
+// real server does: it never sees the key, so it can only hand the cipher text back.
+type fakePrivateBin struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	pastes map[string]fakePaste
+	fail   map[int]bool
+	posts  int
+}
+
+type fakePaste struct {
+	request     CreatePasteRequest
+	deleteToken string
+}
+
+func newFakePrivateBin(t *testing.T) *fakePrivateBin {
+	pb := &fakePrivateBin{pastes: make(map[string]fakePaste), fail: make(map[int]bool)}
+	pb.Server = httptest.NewServer(http.HandlerFunc(pb.handle))
+	t.Cleanup(pb.Close)
+	return pb
+}
+
+// client returns a PBClient for the stand-in.
+func (pb *fakePrivateBin) client(t *testing.T) *PBClient {
+	uri, err := url.Parse(pb.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPBClient(uri, "user", "secret")
+}
+
+// failPost makes the n-th paste creation, counting from 1, fail.
+func (pb *fakePrivateBin) failPost(n int) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	pb.fail[n] = true
+}
+
+func (pb *fakePrivateBin) count() int {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	return len(pb.pastes)
+}
+
+func (pb *fakePrivateBin) handle(w http.ResponseWriter, r *http.Request) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	reply := func(v interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+
+	if r.Method == http.MethodGet {
+		id := r.URL.RawQuery
+		paste, ok := pb.pastes[id]
+		if !ok {
+			reply(GetPasteResponse{Status: 1, Message: "Paste does not exist, has expired or has been deleted."})
+			return
+		}
+
+		adata, _ := json.Marshal(paste.request.AData)
+		reply(GetPasteResponse{ID: id, URL: "/?" + id, V: 2, AData: adata, CT: paste.request.CT})
+		return
+	}
+
+	var body struct {
+		CreatePasteRequest
+		PasteID     string `json:"pasteid"`
+		DeleteToken string `json:"deletetoken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if body.PasteID != "" {
+		paste, ok := pb.pastes[body.PasteID]
+		switch {
+		case !ok:
+			reply(CreatePasteResponse{Status: 1, Message: "Paste does not exist, has expired or has been deleted."})
+		case paste.deleteToken != body.DeleteToken:
+			reply(CreatePasteResponse{Status: 1, Message: "Wrong deletion token. Paste was not deleted."})
+		default:
+			delete(pb.pastes, body.PasteID)
+			reply(CreatePasteResponse{ID: body.PasteID})
+		}
+		return
+	}
+
+	pb.posts++
+	if pb.fail[pb.posts] {
+		http.Error(w, "storage full", http.StatusInternalServerError)
+		return
+	}
+
+	idBytes, tokenBytes := make([]byte, 8), make([]byte, 32)
+	rand.Read(idBytes)
+	rand.Read(tokenBytes)
+	id, token := hex.EncodeToString(idBytes), hex.EncodeToString(tokenBytes)
+
+	pb.pastes[id] = fakePaste{request: body.CreatePasteRequest, deleteToken: token}
+	reply(CreatePasteResponse{ID: id, URL: "/?" + id, DeleteToken: token})
+}
+
+func TestPasteRoundTrip(t *testing.T) {
+	for _, compression := range []string{"none", "zlib"} {
+		t.Run(compression, func(t *testing.T) {
+			pb := newFakePrivateBin(t)
+			pbc := pb.client(t)
+			pbc.Compression = compression
+			ctx := context.Background()
+
+			resp, err := pbc.CreatePaste(ctx, "kubeadmin password", "1day", "plaintext", false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := pbc.GetPaste(ctx, resp.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if content.Paste != "kubeadmin password" {
+				t.Errorf("paste = %q", content.Paste)
+			}
+
+			if err = pbc.DeletePaste(ctx, resp.ID, resp.DeleteToken); err != nil {
+				t.Fatal(err)
+			}
+			if pb.count() != 0 {
+				t.Errorf("%d pastes left after delete", pb.count())
+			}
+		})
+	}
+}
+
+func TestPasteWithPassword(t *testing.T) {
+	pb := newFakePrivateBin(t)
+	pbc := pb.client(t)
+	ctx := context.Background()
+
+	resp, err := pbc.CreatePasteWithPassword(ctx, "kubeconfig", "hunter2", "1week", "plaintext", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := pbc.GetPasteWithPassword(ctx, resp.URL, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.Paste != "kubeconfig" {
+		t.Errorf("paste = %q", content.Paste)
+	}
+
+	for _, password := range []string{"", "hunter3"} {
+		if _, err = pbc.GetPasteWithPassword(ctx, resp.URL, password); err == nil {
+			t.Errorf("paste opened with password %q", password)
+		}
+	}
+}
+
+func TestPasteWithAttachments(t *testing.T) {
+	for _, n := range []int{1, 2} {
+		t.Run(fmt.Sprintf("%d attachments", n), func(t *testing.T) {
+			pb := newFakePrivateBin(t)
+			pbc := pb.client(t)
+			pbc.Compression = "zlib"
+			ctx := context.Background()
+
+			attachments := []PasteAttachment{
+				{Name: "kubeconfig", MediaType: "application/yaml", Data: []byte("apiVersion: v1\n")},
+				{Name: "ssh-privatekey", MediaType: "application/x-pem-file", Data: []byte{0, 1, 2, 0xff}},
+			}[:n]
+
+			resp, err := pbc.CreatePasteWithAttachments(ctx, "lab credentials", attachments, "", "1day",
+				"plaintext", false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := pbc.GetPaste(ctx, resp.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := content.Attachments()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != n {
+				t.Fatalf("got %d attachments, want %d", len(got), n)
+			}
+			for i := range got {
+				want := attachments[i]
+				if got[i].Name != want.Name || got[i].MediaType != want.MediaType || string(got[i].Data) != string(want.Data) {
+					t.Errorf("attachment %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestPasteNotFound(t *testing.T) {
+	pb := newFakePrivateBin(t)
+	pbc := pb.client(t)
+	ctx := context.Background()
+
+	resp, err := pbc.CreatePaste(ctx, "short lived", "5min", "plaintext", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = pbc.DeletePaste(ctx, resp.ID, resp.DeleteToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = pbc.GetPaste(ctx, resp.URL); !errors.Is(err, ErrPasteNotFound) {
+		t.Errorf("get deleted paste: got %v, want ErrPasteNotFound", err)
+	}
+	if err = pbc.DeletePaste(ctx, resp.ID, resp.DeleteToken); !errors.Is(err, ErrPasteNotFound) {
+		t.Errorf("delete deleted paste: got %v, want ErrPasteNotFound", err)
+	}
+}
+
+// TestDecryptWebClientPaste decrypts v2 pastes encrypted the way the PrivateBin web client
+// does: 16 byte IV, padded base64 and the password bytes appended to the master key. The
+// vectors were produced by a port of the web client's CryptTool.cipher to Node's WebCrypto.
+func TestDecryptWebClientPaste(t *testing.T) {
+	masterKey := make([]byte, 32)
+	for i := range masterKey {
+		masterKey[i] = byte(i)
+	}
+	const (
+		password = "correct horse battery staple"
+		message  = `{"paste":"kubeadmin password: Xq7s-Vn2e-Lp9d-Rt4k"}`
+	)
+
+	vectors := []struct {
+		compression string
+		adata       string
+		ct          string
+	}{
+		{
+			"none",
+			`[["oKGio6SlpqeoqaqrrK2urw==","sLGys7S1trc=",100000,256,128,"aes","gcm","none"],"plaintext",0,0]`,
+			"mmOniuZ37rCM5ObJ7gxLaeSFGJsJRrlK4GbRZbjFKu+lFnQcx+lWAmBTaRuA9McGxXljuGMpAKGjEUFJIUj3cfnVCA==",
+		},
+		{
+			"zlib",
+			`[["oKGio6SlpqeoqaqrrK2urw==","sLGys7S1trc=",100000,256,128,"aes","gcm","zlib"],"plaintext",0,0]`,
+			"Shf9o7ktwscElEeSwSNnQUAhuuhRb+YXucaMSzO3+ramSo9HYuh5+tkK4DN8q5kDZw20VbUlMfsWhHJ5rb3wDVIge4wI",
+		},
+	}
+
+	for _, v := range vectors {
+		t.Run(v.compression, func(t *testing.T) {
+			ct, err := decodePasteBase64(v.ct)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			plain, err := decrypt(masterKey, password, json.RawMessage(v.adata), ct)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(plain) != message {
+				t.Errorf("decrypted %q, want %q", plain, message)
+			}
+
+			if _, err = decrypt(masterKey, "", json.RawMessage(v.adata), ct); err == nil {
+				t.Error("paste decrypted without its password")
+			}
+		})
+	}
+}
+
+// TestEncryptWithRand checks the paste written for a fixed random stream. The expected cipher
+// text also decrypts with the Node port of the web client used by TestDecryptWebClientPaste.
+func TestEncryptWithRand(t *testing.T) {
+	stream := make([]byte, 20)
+	for i := range stream {
+		stream[i] = byte(0xc0 + i)
+	}
+	masterKey := make([]byte, 32)
+	for i := range masterKey {
+		masterKey[i] = byte(i)
+	}
+
+	paste, err := encrypt(bytes.NewReader(stream), masterKey, "hunter2", []byte(`{"paste":"kubeconfig"}`),
+		"zlib", "plaintext", false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adata, err := json.Marshal(paste.adata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[["wMHCw8TFxsfIycrL","zM3Oz9DR0tM",100000,256,128,"aes","gcm","zlib"],"plaintext",0,1]`; string(adata) != want {
+		t.Errorf("adata = %s, want %s", adata, want)
+	}
+	if ct, want := base64.RawStdEncoding.EncodeToString(paste.Data), "5VhTjg581MiWAGpr4m49LwQwltDqi6QV+wjXPSfZRy7N0EgBYIQOork"; ct != want {
+		t.Errorf("ct = %s, want %s", ct, want)
+	}
+}
+
+// TestPBClientRand checks that a client with a fixed Rand creates reproducible pastes, while
+// the default source never repeats a key, IV or salt.
+func TestPBClientRand(t *testing.T) {
+	ctx := context.Background()
+	stream := bytes.Repeat([]byte{0x5a}, 52)
+
+	create := func(pb *fakePrivateBin, pbc *PBClient) (string, CreatePasteRequest) {
+		t.Helper()
+		resp, err := pbc.CreatePaste(ctx, "kubeadmin password", "1day", "plaintext", false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pb.mu.Lock()
+		defer pb.mu.Unlock()
+
+		uri, err := url.Parse(resp.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return uri.Fragment, pb.pastes[resp.ID].request
+	}
+
+	pb := newFakePrivateBin(t)
+
+	seeded := pb.client(t)
+	seeded.Rand = bytes.NewReader(stream)
+	key1, paste1 := create(pb, seeded)
+	seeded.Rand = bytes.NewReader(stream)
+	key2, paste2 := create(pb, seeded)
+
+	if key1 != Encode(stream[:32]) {
+		t.Errorf("key %s was not read from Rand", key1)
+	}
+	if key1 != key2 || paste1.CT != paste2.CT {
+		t.Error("pastes from the same random stream differ")
+	}
+
+	random := pb.client(t)
+	key3, paste3 := create(pb, random)
+	key4, paste4 := create(pb, random)
+
+	if key3 == key4 || key3 == key1 {
+		t.Error("default random source repeated a paste key")
+	}
+	spec3, spec4 := paste3.AData[0].([]interface{}), paste4.AData[0].([]interface{})
+	if spec3[0] == spec4[0] || spec3[1] == spec4[1] {
+		t.Error("default random source repeated an iv or salt")
+	}
+	if paste3.CT == paste4.CT {
+		t.Error("identical messages encrypted to the same cipher text")
+	}
+
+	seeded.Rand = bytes.NewReader(stream[:40])
+	if _, err := seeded.CreatePaste(ctx, "short stream", "1day", "plaintext", false, false); err == nil {
+		t.Error("paste created from an exhausted random stream")
+	}
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+)
+
+// PrivateBinExpireOptions are the paste lifetimes accepted by a default PrivateBin server.
+var PrivateBinExpireOptions = []string{"5min", "10min", "1hour", "1day", "1week", "1month", "1year", "never"}
+
+var privateBinFormatters = []string{"plaintext", "syntaxhighlighting", "markdown"}
+
+// LoadPBConfig reads the PrivateBin profiles from the JSON file at path and returns the
+// profile called name, falling back to PRIVATEBIN_PROFILE and then the default of the file.
+// An empty path means PRIVATEBIN_CONFIG. PRIVATEBIN_HOST, PRIVATEBIN_USERNAME,
+// PRIVATEBIN_PASSWORD, PRIVATEBIN_EXPIRE and PRIVATEBIN_COMPRESSION override the profile,
+// so credentials can be kept out of the file.
+func LoadPBConfig(path, name string) (*Cfg, error) {
+	if path == "" {
+		path = os.Getenv("PRIVATEBIN_CONFIG")
+	}
+	if path == "" {
+		return nil, fmt.Errorf("no PrivateBin config file given and PRIVATEBIN_CONFIG is not set")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read PrivateBin config file: %w", err)
+	}
+
+	configFile := PBConfigFile{}
+	if err = json.Unmarshal(data, &configFile); err != nil {
+		return nil, fmt.Errorf("unable to parse PrivateBin config file: %w", err)
+	}
+
+	if name == "" {
+		name = os.Getenv("PRIVATEBIN_PROFILE")
+	}
+	if name == "" {
+		name = configFile.Default
+	}
+
+	var config *Cfg
+	for i := range configFile.Profiles {
+		if configFile.Profiles[i].Name == name {
+			config = &configFile.Profiles[i]
+			break
+		}
+	}
+	if config == nil {
+		return nil, fmt.Errorf("PrivateBin profile %q not found in %s", name, path)
+	}
+
+	overrides := map[string]*string{
+		"PRIVATEBIN_HOST":        &config.Host,
+		"PRIVATEBIN_USERNAME":    &config.Username,
+		"PRIVATEBIN_PASSWORD":    &config.Password,
+		"PRIVATEBIN_EXPIRE":      &config.Expire,
+		"PRIVATEBIN_COMPRESSION": &config.Compression,
+	}
+	for env, field := range overrides {
+		if value, ok := os.LookupEnv(env); ok {
+			*field = value
+		}
+	}
+
+	if err = config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate checks that the profile names a usable host and only uses values PrivateBin accepts.
+func (c *Cfg) Validate() error {
+	if c.Host == "" {
+		return fmt.Errorf("PrivateBin profile %q has no host", c.Name)
+	}
+
+	uri, err := url.Parse(c.Host)
+	if err != nil || uri.Scheme == "" || uri.Host == "" {
+		return fmt.Errorf("PrivateBin profile %q has invalid host %q", c.Name, c.Host)
+	}
+
+	if !Contains(PrivateBinExpireOptions, c.Expire) {
+		return fmt.Errorf("PrivateBin profile %q has invalid expire %q, must be one of %v",
+			c.Name, c.Expire, PrivateBinExpireOptions)
+	}
+
+	if c.Formatter != "" && !Contains(privateBinFormatters, c.Formatter) {
+		return fmt.Errorf("PrivateBin profile %q has invalid formatter %q", c.Name, c.Formatter)
+	}
+
+	if c.Compression != "" && c.Compression != "none" && c.Compression != "zlib" {
+		return fmt.Errorf("PrivateBin profile %q has invalid compression %q", c.Name, c.Compression)
+	}
+
+	return nil
+}
+
+// NewPBClientFromCfg validates config and creates a client for it.
+func NewPBClientFromCfg(config *Cfg) (*PBClient, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	uri, err := url.Parse(config.Host)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q bin host %q: %w", config.Name, config.Host, err)
+	}
+
+	pbc := NewPBClient(uri, config.Username, config.Password)
+	pbc.Compression = config.Compression
+
+	return pbc, nil
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testPBConfigFile = `{
+  "default": "internal",
+  "profiles": [
+    {"name": "internal", "host": "https://bin.internal.example", "expire": "1day", "compression": "zlib"},
+    {"name": "partner", "host": "https://bin.partner.example", "username": "opl", "password": "from-file", "expire": "1week"},
+    {"name": "forever", "host": "https://bin.partner.example", "expire": "1century"},
+    {"name": "packed", "host": "https://bin.partner.example", "expire": "1day", "compression": "gzip"}
+  ]
+}`
+
+// unsetPBEnv clears the PrivateBin environment for the duration of the test.
+func unsetPBEnv(t *testing.T) {
+	t.Helper()
+
+	for _, env := range []string{"PRIVATEBIN_CONFIG", "PRIVATEBIN_PROFILE", "PRIVATEBIN_HOST", "PRIVATEBIN_USERNAME",
+		"PRIVATEBIN_PASSWORD", "PRIVATEBIN_EXPIRE", "PRIVATEBIN_COMPRESSION"} {
+		env := env
+		if value, ok := os.LookupEnv(env); ok {
+			t.Cleanup(func() { os.Setenv(env, value) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(env) })
+		}
+		os.Unsetenv(env)
+	}
+}
+
+func TestLoadPBConfigProfileSelection(t *testing.T) {
+	unsetPBEnv(t)
+	path := writeTestFile(t, t.TempDir(), "privatebin.json", testPBConfigFile)
+
+	config, err := LoadPBConfig(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Name != "internal" || config.Compression != "zlib" {
+		t.Errorf("file default: got profile %+v", config)
+	}
+
+	t.Setenv("PRIVATEBIN_PROFILE", "partner")
+	if config, err = LoadPBConfig(path, ""); err != nil || config.Name != "partner" {
+		t.Errorf("PRIVATEBIN_PROFILE: got %+v, %v", config, err)
+	}
+
+	if config, err = LoadPBConfig(path, "internal"); err != nil || config.Name != "internal" {
+		t.Errorf("named profile: got %+v, %v", config, err)
+	}
+
+	t.Setenv("PRIVATEBIN_CONFIG", path)
+	if config, err = LoadPBConfig("", "partner"); err != nil || config.Host != "https://bin.partner.example" {
+		t.Errorf("PRIVATEBIN_CONFIG: got %+v, %v", config, err)
+	}
+
+	if _, err = LoadPBConfig(path, "missing"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("missing profile: got %v", err)
+	}
+}
+
+func TestLoadPBConfigEnvOverrides(t *testing.T) {
+	unsetPBEnv(t)
+	path := writeTestFile(t, t.TempDir(), "privatebin.json", testPBConfigFile)
+
+	t.Setenv("PRIVATEBIN_HOST", "https://bin.override.example")
+	t.Setenv("PRIVATEBIN_USERNAME", "ops")
+	t.Setenv("PRIVATEBIN_PASSWORD", "from-env")
+	t.Setenv("PRIVATEBIN_EXPIRE", "1hour")
+	t.Setenv("PRIVATEBIN_COMPRESSION", "none")
+
+	config, err := LoadPBConfig(path, "partner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://bin.override.example" || config.Username != "ops" || config.Password != "from-env" ||
+		config.Expire != "1hour" || config.Compression != "none" {
+		t.Errorf("environment not applied: %+v", config)
+	}
+}
+
+func TestLoadPBConfigRejectsInvalidProfiles(t *testing.T) {
+	unsetPBEnv(t)
+	dir := t.TempDir()
+	path := writeTestFile(t, dir, "privatebin.json", testPBConfigFile)
+
+	tests := map[string]struct {
+		profile string
+		env     map[string]string
+		err     string
+	}{
+		"expire":          {profile: "forever", err: "invalid expire"},
+		"compression":     {profile: "packed", err: "invalid compression"},
+		"env expire":      {profile: "internal", env: map[string]string{"PRIVATEBIN_EXPIRE": "forever"}, err: "invalid expire"},
+		"env compression": {profile: "internal", env: map[string]string{"PRIVATEBIN_COMPRESSION": "brotli"}, err: "invalid compression"},
+		"env host":        {profile: "internal", env: map[string]string{"PRIVATEBIN_HOST": "bin.internal.example"}, err: "invalid host"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			for env, value := range tt.env {
+				t.Setenv(env, value)
+			}
+
+			_, err := LoadPBConfig(path, tt.profile)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	if _, err := LoadPBConfig(filepath.Join(dir, "missing.json"), ""); err == nil {
+		t.Error("missing config file accepted")
+	}
+}
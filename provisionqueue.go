@@ -0,0 +1,532 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+	"log"
+	"net"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	QueuedLabPending      QueuedLabState = "pending"
+	QueuedLabProvisioning QueuedLabState = "provisioning"
+	QueuedLabInstalling   QueuedLabState = "installing"
+	QueuedLabFailed       QueuedLabState = "failed"
+)
+
+const (
+	// DefaultProvisioningQueue is the ConfigMap in the hive namespace holding the queue.
+	DefaultProvisioningQueue = "opl-provisioning-queue"
+
+	provisioningQueueKey = "queue"
+)
+
+// NewProvisioningQueue returns the default provisioning queue of the hub with two labs
+// provisioned at a time per region. Set its Limits, e.g. from LoadCapacityLimits, to admit
+// labs against the capacity of their region.
+func NewProvisioningQueue() (*ProvisioningQueue, error) {
+	kc, err := K8sAuthenticate()
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := hiveClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return newProvisioningQueue(kc, dc), nil
+}
+
+func newProvisioningQueue(kc kubernetes.Interface, dc client.Client) *ProvisioningQueue {
+	return &ProvisioningQueue{
+		Client:       kc,
+		Hive:         dc,
+		Namespace:    "hive",
+		Name:         DefaultProvisioningQueue,
+		Concurrency:  2,
+		MaxAttempts:  5,
+		Backoff:      wait.Backoff{Duration: time.Minute, Factor: 2, Jitter: 0.1, Steps: 5, Cap: 30 * time.Minute},
+		PollInterval: 30 * time.Second,
+		Provision:    CreateClusterDeployment,
+		IsTransient:  IsTransientError,
+	}
+}
+
+// IsTransientError reports whether err is worth retrying: API server timeouts, throttling,
+// conflicts and unavailability, and network timeouts.
+func IsTransientError(err error) bool {
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) || apierrors.IsConflict(err) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func newQueuedLabRequest(labRequest *LabRequest) QueuedLabRequest {
+	return QueuedLabRequest{
+		ID:               labRequest.ID,
+		ClusterName:      labRequest.ClusterName,
+		ShortID:          labRequest.ShortID,
+		ClusterSize:      labRequest.ClusterSize,
+		LeaseTime:        labRequest.LeaseTime,
+		Availability:     labRequest.Availability,
+		PublicSSHKey:     labRequest.PublicSSHKey,
+		OpenShiftVersion: labRequest.OpenShiftVersion,
+	}
+}
+
+// LabRequest returns r as a LabRequest for provisioning.
+func (r QueuedLabRequest) LabRequest() *LabRequest {
+	return &LabRequest{
+		ID:               r.ID,
+		ClusterName:      r.ClusterName,
+		ShortID:          r.ShortID,
+		ClusterSize:      r.ClusterSize,
+		LeaseTime:        r.LeaseTime,
+		Availability:     r.Availability,
+		PublicSSHKey:     r.PublicSSHKey,
+		OpenShiftVersion: r.OpenShiftVersion,
+	}
+}
+
+// Enqueue adds an approved labRequest to the queue and returns its position in its region.
+// Only the fields of QueuedLabRequest are kept. Enqueueing a lab that is already queued only
+// returns its position.
+func (q *ProvisioningQueue) Enqueue(ctx context.Context, labRequest *LabRequest) (int, error) {
+	err := q.update(ctx, func(queue []QueuedLab) ([]QueuedLab, error) {
+		for _, lab := range queue {
+			if lab.Request.ID == labRequest.ID {
+				return queue, nil
+			}
+		}
+
+		return append(queue, QueuedLab{
+			Request:    newQueuedLabRequest(labRequest),
+			Provider:   DefaultLabProvider,
+			Region:     DefaultLabRegion,
+			State:      QueuedLabPending,
+			EnqueuedAt: time.Now().UTC(),
+		}), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return q.Position(ctx, labRequest.ID)
+}
+
+// Position returns the 1-based position of lab labID among the pending labs of its region,
+// or 0 once it is being provisioned or installed. Failed labs and labs not in the queue return
+// an error.
+func (q *ProvisioningQueue) Position(ctx context.Context, labID uuid.UUID) (int, error) {
+	queue, _, err := q.load(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, lab := range queue {
+		if lab.Request.ID != labID {
+			continue
+		}
+
+		switch lab.State {
+		case QueuedLabProvisioning, QueuedLabInstalling:
+			return 0, nil
+		case QueuedLabFailed:
+			return 0, fmt.Errorf("provisioning lab %s failed after %d attempts: %s", labID, lab.Attempts, lab.LastError)
+		}
+
+		position := 1
+		for _, other := range queue {
+			if other.State == QueuedLabPending && other.Provider == lab.Provider && other.Region == lab.Region &&
+				other.EnqueuedAt.Before(lab.EnqueuedAt) {
+				position++
+			}
+		}
+		return position, nil
+	}
+
+	return 0, fmt.Errorf("%w: %s is not queued", ErrLabNotFound, labID)
+}
+
+// Remove drops lab labID from the queue, e.g. to retry a failed lab with Enqueue.
+func (q *ProvisioningQueue) Remove(ctx context.Context, labID uuid.UUID) error {
+	return q.update(ctx, func(queue []QueuedLab) ([]QueuedLab, error) {
+		return removeQueuedLab(queue, labID), nil
+	})
+}
+
+// Run provisions queued labs until ctx is done. A lab keeps its place in the concurrency of its
+// region until its ClusterDeployment is installed, when it leaves the queue, or hive stops
+// provisioning it, when it fails. Only one Run may work a queue at a time: labs left
+// provisioning by a previous Run are put back to pending when it starts.
+func (q *ProvisioningQueue) Run(ctx context.Context) error {
+	err := q.update(ctx, func(queue []QueuedLab) ([]QueuedLab, error) {
+		for i := range queue {
+			if queue[i].State == QueuedLabProvisioning {
+				queue[i].State = QueuedLabPending
+			}
+		}
+		return queue, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := q.checkInstalling(ctx); err != nil {
+			log.Printf("unable to check installing labs: %v", err)
+		}
+
+		started, err := q.startReady(ctx)
+		if err != nil {
+			log.Printf("unable to start queued labs: %v", err)
+		}
+		for _, lab := range started {
+			wg.Add(1)
+			go func(lab QueuedLab) {
+				defer wg.Done()
+				q.provision(ctx, lab)
+			}(lab)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// startReady marks the pending labs that are due and fit in the concurrency of their region
+// as provisioning and returns them, oldest first. With Limits set, a lab also has to be
+// admitted by the capacity planner: a lab that has to wait for capacity holds back the younger
+// labs of its region, and one that can never fit fails.
+func (q *ProvisioningQueue) startReady(ctx context.Context) ([]QueuedLab, error) {
+	var started []QueuedLab
+
+	err := q.update(ctx, func(queue []QueuedLab) ([]QueuedLab, error) {
+		started = nil
+
+		var planned []CapacityUsage
+		if q.Limits != nil {
+			var err error
+			if planned, err = q.plannedUsage(ctx, queue); err != nil {
+				return nil, err
+			}
+		}
+
+		busy := make(map[string]int)
+		blocked := make(map[string]bool)
+		for _, lab := range queue {
+			if lab.State == QueuedLabProvisioning || lab.State == QueuedLabInstalling {
+				busy[lab.Provider+"/"+lab.Region]++
+			}
+		}
+
+		order := make([]int, len(queue))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool {
+			return queue[order[a]].EnqueuedAt.Before(queue[order[b]].EnqueuedAt)
+		})
+
+		now := time.Now()
+		for _, i := range order {
+			lab := &queue[i]
+			if lab.State != QueuedLabPending || now.Before(lab.NextAttempt) {
+				continue
+			}
+
+			var plan *CapacityPlan
+			if q.Limits != nil {
+				var err error
+				if plan, err = planLab(planned, lab.Request.LabRequest(), lab.Provider, lab.Region); err != nil {
+					lab.State = QueuedLabFailed
+					lab.LastError = err.Error()
+					continue
+				}
+				if plan.Decision == CapacityRefuse {
+					lab.State = QueuedLabFailed
+					lab.LastError = plan.Reason
+					continue
+				}
+			}
+
+			key := lab.Provider + "/" + lab.Region
+			if blocked[key] || busy[key] >= q.Concurrency {
+				continue
+			}
+
+			if plan != nil {
+				if plan.Decision == CapacityQueue {
+					lab.Waiting = plan.Reason
+					blocked[key] = true
+					continue
+				}
+				// labs started here have no ClusterDeployment to count yet
+				reserveCapacity(&planned, lab.Provider, lab.Region, plan.RequestedVCPU)
+			}
+
+			busy[key]++
+			lab.State = QueuedLabProvisioning
+			lab.Attempts++
+			lab.Waiting = ""
+			started = append(started, *lab)
+		}
+
+		return queue, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return started, nil
+}
+
+// plannedUsage returns the capacity used on the hub plus the labs of queue that are being
+// provisioned but have no ClusterDeployment yet. Those are looked up before the hub is listed,
+// so a ClusterDeployment created in between is counted twice rather than not at all.
+func (q *ProvisioningQueue) plannedUsage(ctx context.Context, queue []QueuedLab) ([]CapacityUsage, error) {
+	var provisioning []QueuedLab
+	for _, lab := range queue {
+		if lab.State != QueuedLabProvisioning {
+			continue
+		}
+
+		cd := hivev1.ClusterDeployment{}
+		err := q.Hive.Get(ctx, types.NamespacedName{Namespace: "hive", Name: lab.Request.ID.String()}, &cd)
+		switch {
+		case apierrors.IsNotFound(err):
+			provisioning = append(provisioning, lab)
+		case err != nil:
+			return nil, fmt.Errorf("unable to get cluster deployment %s: %w", lab.Request.ID, err)
+		}
+	}
+
+	usage, err := getCapacityUsage(ctx, q.Hive, q.Limits)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lab := range provisioning {
+		size, err := clusterSize(lab.Request.ClusterSize)
+		if err != nil {
+			return nil, fmt.Errorf("lab %s: %w", lab.Request.ID, err)
+		}
+		vcpu, err := size.VCPU()
+		if err != nil {
+			return nil, fmt.Errorf("lab %s: %w", lab.Request.ID, err)
+		}
+		reserveCapacity(&usage, lab.Provider, lab.Region, vcpu)
+	}
+
+	return usage, nil
+}
+
+// reserveCapacity adds a lab of vcpu vCPUs to the usage of provider region.
+func reserveCapacity(usage *[]CapacityUsage, provider, region string, vcpu int) {
+	for i := range *usage {
+		if u := &(*usage)[i]; u.Provider == provider && u.Region == region {
+			u.Labs++
+			u.VCPU += vcpu
+			return
+		}
+	}
+	*usage = append(*usage, CapacityUsage{Provider: provider, Region: region, Labs: 1, VCPU: vcpu})
+}
+
+// checkInstalling drops the installing labs whose ClusterDeployment is installed from the queue
+// and fails those whose provisioning hive stopped or whose ClusterDeployment is gone.
+func (q *ProvisioningQueue) checkInstalling(ctx context.Context) error {
+	queue, _, err := q.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	installed := make(map[uuid.UUID]bool)
+	failed := make(map[uuid.UUID]string)
+	for _, lab := range queue {
+		if lab.State != QueuedLabInstalling {
+			continue
+		}
+
+		cd := hivev1.ClusterDeployment{}
+		err := q.Hive.Get(ctx, types.NamespacedName{Namespace: "hive", Name: lab.Request.ID.String()}, &cd)
+		switch {
+		case apierrors.IsNotFound(err):
+			failed[lab.Request.ID] = "cluster deployment was deleted before it was installed"
+		case err != nil:
+			return fmt.Errorf("unable to get cluster deployment %s: %w", lab.Request.ID, err)
+		case cd.Spec.Installed:
+			installed[lab.Request.ID] = true
+		default:
+			for _, condition := range cd.Status.Conditions {
+				if condition.Type == hivev1.ProvisionStoppedCondition && condition.Status == corev1.ConditionTrue {
+					failed[lab.Request.ID] = fmt.Sprintf("provisioning stopped: %s", condition.Message)
+				}
+			}
+		}
+	}
+
+	if len(installed) == 0 && len(failed) == 0 {
+		return nil
+	}
+
+	return q.update(ctx, func(queue []QueuedLab) ([]QueuedLab, error) {
+		var kept []QueuedLab
+		for _, lab := range queue {
+			if lab.State == QueuedLabInstalling {
+				if installed[lab.Request.ID] {
+					continue
+				}
+				if reason, ok := failed[lab.Request.ID]; ok {
+					lab.State = QueuedLabFailed
+					lab.LastError = reason
+				}
+			}
+			kept = append(kept, lab)
+		}
+		return kept, nil
+	})
+}
+
+// provision runs a single attempt of lab and records its outcome in the queue. A created lab
+// stays in the queue as installing; a ClusterDeployment left by an earlier attempt counts as
+// created.
+func (q *ProvisioningQueue) provision(ctx context.Context, lab QueuedLab) {
+	provisionErr := q.Provision(ctx, lab.Request.LabRequest())
+
+	// record the outcome even when ctx was cancelled during the attempt
+	err := q.update(context.Background(), func(queue []QueuedLab) ([]QueuedLab, error) {
+		for i := range queue {
+			if queue[i].Request.ID != lab.Request.ID {
+				continue
+			}
+
+			if provisionErr == nil || apierrors.IsAlreadyExists(provisionErr) {
+				queue[i].State = QueuedLabInstalling
+				queue[i].LastError = ""
+				continue
+			}
+
+			queue[i].LastError = provisionErr.Error()
+			if q.IsTransient(provisionErr) && queue[i].Attempts < q.MaxAttempts {
+				queue[i].State = QueuedLabPending
+				queue[i].NextAttempt = time.Now().Add(q.retryDelay(queue[i].Attempts))
+			} else {
+				queue[i].State = QueuedLabFailed
+			}
+		}
+		return queue, nil
+	})
+	if err != nil {
+		log.Printf("unable to record provisioning of lab %s: %v", lab.Request.ID, err)
+	}
+}
+
+// retryDelay returns the backoff before the attempt following attempts failed ones.
+func (q *ProvisioningQueue) retryDelay(attempts int) time.Duration {
+	backoff := q.Backoff
+
+	var delay time.Duration
+	for i := 0; i < attempts; i++ {
+		delay = backoff.Step()
+	}
+	return delay
+}
+
+func removeQueuedLab(queue []QueuedLab, labID uuid.UUID) []QueuedLab {
+	var kept []QueuedLab
+	for _, lab := range queue {
+		if lab.Request.ID != labID {
+			kept = append(kept, lab)
+		}
+	}
+	return kept
+}
+
+// load reads the queue from its ConfigMap. A missing ConfigMap is an empty queue.
+func (q *ProvisioningQueue) load(ctx context.Context) ([]QueuedLab, *corev1.ConfigMap, error) {
+	cm, err := q.Client.CoreV1().ConfigMaps(q.Namespace).Get(ctx, q.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get provisioning queue: %w", err)
+	}
+
+	var queue []QueuedLab
+	if data := cm.Data[provisioningQueueKey]; data != "" {
+		if err = json.Unmarshal([]byte(data), &queue); err != nil {
+			return nil, nil, fmt.Errorf("unable to parse provisioning queue: %w", err)
+		}
+	}
+
+	return queue, cm, nil
+}
+
+// update rewrites the queue with fn, retrying on conflicts.
+func (q *ProvisioningQueue) update(ctx context.Context, fn func([]QueuedLab) ([]QueuedLab, error)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		queue, cm, err := q.load(ctx)
+		if err != nil {
+			return err
+		}
+
+		queue, err = fn(queue)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(queue)
+		if err != nil {
+			return fmt.Errorf("unable to marshal provisioning queue: %w", err)
+		}
+
+		configMaps := q.Client.CoreV1().ConfigMaps(q.Namespace)
+		if cm == nil {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: q.Name, Namespace: q.Namespace},
+				Data:       map[string]string{provisioningQueueKey: string(data)},
+			}
+			_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), q.Name, err)
+			}
+			return err
+		}
+
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[provisioningQueueKey] = string(data)
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,297 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestProvisioningQueue returns a queue whose labs are provisioned as ClusterDeployments in dc.
+func newTestProvisioningQueue(t *testing.T, dc client.Client) *ProvisioningQueue {
+	t.Helper()
+
+	q := newProvisioningQueue(k8sfake.NewSimpleClientset(), dc)
+	q.Provision = func(ctx context.Context, labRequest *LabRequest) error {
+		return dc.Create(ctx, newRegionClusterDeployment(labRequest.ID.String(), DefaultLabRegion,
+			strconv.Itoa(labRequest.ClusterSize)))
+	}
+	return q
+}
+
+// enqueueTestLabs enqueues a lab of each of sizes and returns their IDs in order.
+func enqueueTestLabs(t *testing.T, q *ProvisioningQueue, sizes ...int) []uuid.UUID {
+	t.Helper()
+
+	var ids []uuid.UUID
+	for _, size := range sizes {
+		labRequest := newTestQueuedLabRequest()
+		labRequest.ID = uuid.New()
+		labRequest.ClusterSize = size
+		if _, err := q.Enqueue(context.Background(), labRequest); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, labRequest.ID)
+	}
+	return ids
+}
+
+// startTestLabs starts the ready labs of q and provisions them.
+func startTestLabs(t *testing.T, q *ProvisioningQueue) []uuid.UUID {
+	t.Helper()
+
+	started, err := q.startReady(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uuid.UUID
+	for _, lab := range started {
+		q.provision(context.Background(), lab)
+		ids = append(ids, lab.Request.ID)
+	}
+	return ids
+}
+
+func queuedLab(t *testing.T, q *ProvisioningQueue, labID uuid.UUID) *QueuedLab {
+	t.Helper()
+
+	queue, _, err := q.load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, lab := range queue {
+		if lab.Request.ID == labID {
+			return &lab
+		}
+	}
+	return nil
+}
+
+func newTestQueuedLabRequest() *LabRequest {
+	return &LabRequest{
+		ID:                    uuid.MustParse(testLabID),
+		LeaseTime:             1,
+		PrimaryContactName:    "Jane Partner",
+		PrimaryContactEmail:   "jane@acme.example",
+		SecondaryContactName:  "John Partner",
+		SecondaryContactEmail: "john@acme.example",
+		RedHatSponsor:         "sponsor@redhat.example",
+		CompanyName:           "Acme",
+		Availability:          "Americas",
+		ClusterName:           "acme",
+		ShortID:               "kr8noc",
+		ClusterSize:           1,
+		OpenShiftVersion:      "4.7",
+	}
+}
+
+func TestEnqueueKeepsNoContactDetails(t *testing.T) {
+	ctx := context.Background()
+	q := newProvisioningQueue(k8sfake.NewSimpleClientset(), newFakeHiveClient(t))
+	labRequest := newTestQueuedLabRequest()
+
+	position, err := q.Enqueue(ctx, labRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position != 1 {
+		t.Errorf("got position %d, want 1", position)
+	}
+
+	cm, err := q.Client.CoreV1().ConfigMaps("hive").Get(ctx, DefaultProvisioningQueue, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, detail := range []string{"Jane", "John", "@acme.example", "@redhat.example", "Acme"} {
+		if strings.Contains(cm.Data[provisioningQueueKey], detail) {
+			t.Errorf("queue holds %q: %s", detail, cm.Data[provisioningQueueKey])
+		}
+	}
+
+	queue, _, err := q.load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 {
+		t.Fatalf("got %d queued labs", len(queue))
+	}
+
+	provisioned := queue[0].Request.LabRequest()
+	if provisioned.ID != labRequest.ID || provisioned.ClusterName != "acme" || provisioned.ShortID != "kr8noc" ||
+		provisioned.ClusterSize != 1 || provisioned.LeaseTime != 1 || provisioned.Availability != "Americas" ||
+		provisioned.OpenShiftVersion != "4.7" {
+		t.Errorf("provisioning fields lost: %+v", provisioned)
+	}
+}
+
+func TestProvisioningQueueHoldsSlotUntilInstalled(t *testing.T) {
+	ctx := context.Background()
+	dc := newFakeHiveClient(t)
+	q := newTestProvisioningQueue(t, dc)
+	q.Concurrency = 1
+	ids := enqueueTestLabs(t, q, 0, 0)
+
+	if started := startTestLabs(t, q); len(started) != 1 || started[0] != ids[0] {
+		t.Fatalf("started %v, want %s", started, ids[0])
+	}
+	if lab := queuedLab(t, q, ids[0]); lab == nil || lab.State != QueuedLabInstalling {
+		t.Fatalf("created lab is %+v, want installing", lab)
+	}
+
+	// the first lab is still installing and holds the only slot
+	if err := q.checkInstalling(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if started := startTestLabs(t, q); len(started) != 0 {
+		t.Fatalf("started %v while a lab is installing", started)
+	}
+	if position, err := q.Position(ctx, ids[1]); err != nil || position != 1 {
+		t.Errorf("waiting lab at position %d, %v", position, err)
+	}
+
+	cd := hivev1.ClusterDeployment{}
+	if err := dc.Get(ctx, types.NamespacedName{Namespace: "hive", Name: ids[0].String()}, &cd); err != nil {
+		t.Fatal(err)
+	}
+	cd.Spec.Installed = true
+	if err := dc.Update(ctx, &cd); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := q.checkInstalling(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if lab := queuedLab(t, q, ids[0]); lab != nil {
+		t.Errorf("installed lab still queued: %+v", lab)
+	}
+	if started := startTestLabs(t, q); len(started) != 1 || started[0] != ids[1] {
+		t.Fatalf("started %v, want %s", started, ids[1])
+	}
+}
+
+func TestProvisioningQueueFailsStoppedInstall(t *testing.T) {
+	ctx := context.Background()
+	dc := newFakeHiveClient(t)
+	q := newTestProvisioningQueue(t, dc)
+	ids := enqueueTestLabs(t, q, 0)
+
+	// a ClusterDeployment left by an earlier attempt counts as created
+	cd := newRegionClusterDeployment(ids[0].String(), DefaultLabRegion, "0")
+	cd.Status.Conditions = []hivev1.ClusterDeploymentCondition{{
+		Type:    hivev1.ProvisionStoppedCondition,
+		Status:  corev1.ConditionTrue,
+		Message: "Provisioning failed terminally",
+	}}
+	if err := dc.Create(ctx, cd); err != nil {
+		t.Fatal(err)
+	}
+
+	startTestLabs(t, q)
+	if lab := queuedLab(t, q, ids[0]); lab == nil || lab.State != QueuedLabInstalling {
+		t.Fatalf("lab is %+v, want installing", lab)
+	}
+
+	if err := q.checkInstalling(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Position(ctx, ids[0]); err == nil || !strings.Contains(err.Error(), "Provisioning failed terminally") {
+		t.Errorf("got %v, want the provisioning failure", err)
+	}
+}
+
+func TestProvisioningQueuePlansCapacity(t *testing.T) {
+	ctx := context.Background()
+	dc := newFakeHiveClient(t, newRegionClusterDeployment("running-lab", DefaultLabRegion, "1"))
+	q := newTestProvisioningQueue(t, dc)
+	q.Limits = map[string]CapacityLimit{DefaultLabProvider + "/" + DefaultLabRegion: {MaxVCPU: 30}}
+
+	// 24 vCPUs are in use: the first lab has to wait, the second never fits and the third
+	// would fit but must not overtake the first
+	ids := enqueueTestLabs(t, q, 1, 2, 0)
+
+	if started := startTestLabs(t, q); len(started) != 0 {
+		t.Fatalf("started %v without capacity", started)
+	}
+	if lab := queuedLab(t, q, ids[0]); lab.State != QueuedLabPending || lab.Waiting == "" {
+		t.Errorf("first lab is %+v, want pending for capacity", lab)
+	}
+	if lab := queuedLab(t, q, ids[1]); lab.State != QueuedLabFailed {
+		t.Errorf("second lab is %+v, want failed", lab)
+	}
+	if lab := queuedLab(t, q, ids[2]); lab.State != QueuedLabPending {
+		t.Errorf("third lab is %+v, want pending", lab)
+	}
+
+	if err := dc.Delete(ctx, newRegionClusterDeployment("running-lab", DefaultLabRegion, "1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 24 and 18 vCPUs do not fit in 30 together, so only the first lab starts
+	if started := startTestLabs(t, q); len(started) != 1 || started[0] != ids[0] {
+		t.Fatalf("started %v, want %s", started, ids[0])
+	}
+	if lab := queuedLab(t, q, ids[0]); lab.Waiting != "" {
+		t.Errorf("started lab still waiting: %s", lab.Waiting)
+	}
+}
+
+func TestProvisioningQueueCountsLabsBeingProvisioned(t *testing.T) {
+	ctx := context.Background()
+	q := newTestProvisioningQueue(t, newFakeHiveClient(t))
+	q.Limits = map[string]CapacityLimit{DefaultLabProvider + "/" + DefaultLabRegion: {MaxVCPU: 30}}
+	ids := enqueueTestLabs(t, q, 0, 0)
+
+	started, err := q.startReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(started) != 1 || started[0].Request.ID != ids[0] {
+		t.Fatalf("started %v, want %s", started, ids[0])
+	}
+	first := started[0]
+
+	// the first lab is still being provisioned and has no ClusterDeployment yet, but its 18
+	// vCPUs leave no room for the second one
+	if started, err = q.startReady(ctx); err != nil || len(started) != 0 {
+		t.Fatalf("started %v, %v while the first lab is provisioned", started, err)
+	}
+	if lab := queuedLab(t, q, ids[1]); lab.State != QueuedLabPending || lab.Waiting == "" {
+		t.Errorf("second lab is %+v, want pending for capacity", lab)
+	}
+
+	// once created, the first lab is counted from its ClusterDeployment alone
+	q.provision(ctx, first)
+	if started, err = q.startReady(ctx); err != nil || len(started) != 0 {
+		t.Fatalf("started %v, %v after the first lab was created", started, err)
+	}
+	if lab := queuedLab(t, q, ids[1]); !strings.Contains(lab.Waiting, "18 of 30 vCPUs") {
+		t.Errorf("second lab waits for %q, want 18 vCPUs in use", lab.Waiting)
+	}
+}
+
+func TestProvisioningQueueRetriesTransientFailures(t *testing.T) {
+	q := newTestProvisioningQueue(t, newFakeHiveClient(t))
+	q.MaxAttempts = 2
+	q.IsTransient = func(error) bool { return true }
+	q.Provision = func(context.Context, *LabRequest) error { return errors.New("hub unavailable") }
+	ids := enqueueTestLabs(t, q, 0)
+
+	startTestLabs(t, q)
+	lab := queuedLab(t, q, ids[0])
+	if lab.State != QueuedLabPending || lab.Attempts != 1 || lab.NextAttempt.IsZero() {
+		t.Fatalf("lab is %+v, want pending for a retry", lab)
+	}
+
+	if started := startTestLabs(t, q); len(started) != 0 {
+		t.Fatalf("started %v before its backoff", started)
+	}
+}
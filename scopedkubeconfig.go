@@ -0,0 +1,232 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"time"
+)
+
+const (
+	DefaultScopedNamespace      = "opl-partner"
+	DefaultScopedServiceAccount = "opl-partner-admin"
+	DefaultScopedClusterRole    = "cluster-admin"
+)
+
+func (o *ScopedKubeconfigOptions) withDefaults() ScopedKubeconfigOptions {
+	opts := ScopedKubeconfigOptions{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultScopedNamespace
+	}
+	if opts.ServiceAccount == "" {
+		opts.ServiceAccount = DefaultScopedServiceAccount
+	}
+	if opts.ClusterRole == "" {
+		opts.ClusterRole = DefaultScopedClusterRole
+	}
+	if opts.TokenTimeout == 0 {
+		opts.TokenTimeout = 2 * time.Minute
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 2 * time.Second
+	}
+	return opts
+}
+
+func (o *ScopedKubeconfigOptions) tokenSecretName() string {
+	return o.ServiceAccount + "-token"
+}
+
+// ScopeLabCredentials replaces the admin kubeconfig in creds with a kubeconfig for a
+// ServiceAccount minted on the lab cluster and drops the kubeadmin password, so the kubeadmin
+// credential stays internal and the partner access can be revoked on its own.
+func ScopeLabCredentials(ctx context.Context, creds *LabCredentials, opts *ScopedKubeconfigOptions) error {
+	kubeconfig, err := ScopedKubeconfig(ctx, creds.Kubeconfig, opts)
+	if err != nil {
+		return fmt.Errorf("unable to scope credentials of lab %s: %w", creds.LabID, err)
+	}
+
+	creds.Kubeconfig = kubeconfig
+	creds.AdminPassword = ""
+
+	return nil
+}
+
+// ScopedKubeconfig uses adminKubeconfig to create a ServiceAccount bound to the configured
+// ClusterRole on the lab cluster and returns a kubeconfig authenticating as that
+// ServiceAccount against the same API server.
+func ScopedKubeconfig(ctx context.Context, adminKubeconfig []byte, opts *ScopedKubeconfigOptions) ([]byte, error) {
+	kc, cluster, err := labClusterClient(adminKubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return scopedKubeconfig(ctx, kc, cluster, opts)
+}
+
+func scopedKubeconfig(ctx context.Context, kc kubernetes.Interface, cluster *clientcmdapi.Cluster,
+	opts *ScopedKubeconfigOptions) ([]byte, error) {
+	o := opts.withDefaults()
+
+	token, err := mintServiceAccountToken(ctx, kc, &o)
+	if err != nil {
+		return nil, err
+	}
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters["lab"] = cluster
+	config.AuthInfos[o.ServiceAccount] = &clientcmdapi.AuthInfo{Token: token}
+	config.Contexts[o.ServiceAccount] = &clientcmdapi.Context{
+		Cluster:   "lab",
+		AuthInfo:  o.ServiceAccount,
+		Namespace: "default",
+	}
+	config.CurrentContext = o.ServiceAccount
+
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write scoped kubeconfig: %w", err)
+	}
+
+	return data, nil
+}
+
+// RevokeScopedKubeconfig invalidates kubeconfigs minted by ScopedKubeconfig by deleting the
+// ServiceAccount token secret and the ClusterRoleBinding on the lab cluster.
+func RevokeScopedKubeconfig(ctx context.Context, adminKubeconfig []byte, opts *ScopedKubeconfigOptions) error {
+	kc, _, err := labClusterClient(adminKubeconfig)
+	if err != nil {
+		return err
+	}
+
+	o := opts.withDefaults()
+
+	err = kc.CoreV1().Secrets(o.Namespace).Delete(ctx, o.tokenSecretName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete token secret: %w", err)
+	}
+
+	err = kc.RbacV1().ClusterRoleBindings().Delete(ctx, o.ServiceAccount, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("unable to delete cluster role binding: %w", err)
+	}
+
+	return nil
+}
+
+// labClusterClient builds a client for the lab cluster from its admin kubeconfig and returns it
+// with the cluster entry of the current context.
+func labClusterClient(adminKubeconfig []byte) (kubernetes.Interface, *clientcmdapi.Cluster, error) {
+	config, err := clientcmd.Load(adminKubeconfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to load admin kubeconfig: %w", err)
+	}
+
+	currentContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return nil, nil, fmt.Errorf("admin kubeconfig has no current context")
+	}
+
+	cluster, ok := config.Clusters[currentContext.Cluster]
+	if !ok {
+		return nil, nil, fmt.Errorf("admin kubeconfig has no cluster %q", currentContext.Cluster)
+	}
+
+	restConfig, err := clientcmd.NewDefaultClientConfig(*config, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create lab client config: %w", err)
+	}
+
+	kc, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create lab client: %w", err)
+	}
+
+	return kc, cluster, nil
+}
+
+// mintServiceAccountToken makes sure the ServiceAccount, its ClusterRoleBinding and a token
+// secret exist and waits for the token controller to fill the secret.
+func mintServiceAccountToken(ctx context.Context, kc kubernetes.Interface, o *ScopedKubeconfigOptions) (string, error) {
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: o.Namespace}}
+	if _, err := kc.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("unable to create namespace %s: %w", o.Namespace, err)
+	}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: o.ServiceAccount, Namespace: o.Namespace}}
+	if _, err := kc.CoreV1().ServiceAccounts(o.Namespace).Create(ctx, sa, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("unable to create service account %s: %w", o.ServiceAccount, err)
+	}
+
+	crb := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: o.ServiceAccount},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     o.ClusterRole,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      o.ServiceAccount,
+			Namespace: o.Namespace,
+		}},
+	}
+	_, err := kc.RbacV1().ClusterRoleBindings().Create(ctx, crb, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// the role ref of a binding is immutable, so replace bindings to a different role
+		existing, getErr := kc.RbacV1().ClusterRoleBindings().Get(ctx, o.ServiceAccount, metav1.GetOptions{})
+		if getErr != nil {
+			return "", fmt.Errorf("unable to get cluster role binding %s: %w", o.ServiceAccount, getErr)
+		}
+		err = nil
+		if existing.RoleRef != crb.RoleRef {
+			if err = kc.RbacV1().ClusterRoleBindings().Delete(ctx, o.ServiceAccount, metav1.DeleteOptions{}); err == nil {
+				_, err = kc.RbacV1().ClusterRoleBindings().Create(ctx, crb, metav1.CreateOptions{})
+			}
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to bind %s to %s: %w", o.ServiceAccount, o.ClusterRole, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        o.tokenSecretName(),
+			Namespace:   o.Namespace,
+			Annotations: map[string]string{corev1.ServiceAccountNameKey: o.ServiceAccount},
+		},
+		Type: corev1.SecretTypeServiceAccountToken,
+	}
+	_, err = kc.CoreV1().Secrets(o.Namespace).Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", fmt.Errorf("unable to create token secret: %w", err)
+	}
+
+	var token string
+	err = wait.PollImmediate(o.PollInterval, o.TokenTimeout, func() (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		s, err := kc.CoreV1().Secrets(o.Namespace).Get(ctx, o.tokenSecretName(), metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		token = string(s.Data[corev1.ServiceAccountTokenKey])
+		return token != "", nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to get token of service account %s: %w", o.ServiceAccount, err)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"testing"
+	"time"
+)
+
+var testScopedOptions = &ScopedKubeconfigOptions{TokenTimeout: time.Second, PollInterval: time.Millisecond}
+
+// testLabCluster is the cluster entry of a lab admin kubeconfig as clientcmd.Load returns it.
+var testLabCluster = func() *clientcmdapi.Cluster {
+	cluster := clientcmdapi.NewCluster()
+	cluster.Server = "https://api.acme-kr8noc.opdev.io:6443"
+	cluster.CertificateAuthorityData = []byte("-----BEGIN CERTIFICATE-----\nlab CA\n-----END CERTIFICATE-----\n")
+	return cluster
+}()
+
+// fillTokenAfter makes the token controller of kc fill the token secret on the nth get.
+func fillTokenAfter(kc *k8sfake.Clientset, n int) {
+	gets := 0
+	kc.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gets++
+		if gets < n {
+			return false, nil, nil
+		}
+
+		get := action.(k8stesting.GetAction)
+		obj, err := kc.Tracker().Get(corev1.SchemeGroupVersion.WithResource("secrets"), get.GetNamespace(), get.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+		secret := obj.(*corev1.Secret).DeepCopy()
+		secret.Data = map[string][]byte{corev1.ServiceAccountTokenKey: []byte("partner-token")}
+		return true, secret, nil
+	})
+}
+
+func getClusterRoleBinding(t *testing.T, kc kubernetes.Interface) *rbacv1.ClusterRoleBinding {
+	t.Helper()
+
+	crb, err := kc.RbacV1().ClusterRoleBindings().Get(context.Background(), DefaultScopedServiceAccount, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crb
+}
+
+func TestScopedKubeconfig(t *testing.T) {
+	kc := k8sfake.NewSimpleClientset()
+	fillTokenAfter(kc, 3)
+
+	data, err := scopedKubeconfig(context.Background(), kc, testLabCluster, testScopedOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := config.Contexts[config.CurrentContext]
+	if current == nil || current.AuthInfo != DefaultScopedServiceAccount {
+		t.Fatalf("unexpected current context %+v", current)
+	}
+	if token := config.AuthInfos[current.AuthInfo].Token; token != "partner-token" {
+		t.Errorf("got token %q, want the service account token", token)
+	}
+	cluster := config.Clusters[current.Cluster]
+	if cluster == nil || cluster.Server != testLabCluster.Server ||
+		!bytes.Equal(cluster.CertificateAuthorityData, testLabCluster.CertificateAuthorityData) {
+		t.Errorf("got cluster %+v, want the lab API server and CA", cluster)
+	}
+
+	crb := getClusterRoleBinding(t, kc)
+	if crb.RoleRef.Kind != "ClusterRole" || crb.RoleRef.Name != DefaultScopedClusterRole {
+		t.Errorf("got role ref %+v", crb.RoleRef)
+	}
+	if len(crb.Subjects) != 1 || crb.Subjects[0].Name != DefaultScopedServiceAccount ||
+		crb.Subjects[0].Namespace != DefaultScopedNamespace {
+		t.Errorf("got subjects %+v", crb.Subjects)
+	}
+}
+
+func TestScopedKubeconfigReplacesBindingOfOtherRole(t *testing.T) {
+	kc := k8sfake.NewSimpleClientset(&rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultScopedServiceAccount},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+	})
+	fillTokenAfter(kc, 1)
+
+	opts := *testScopedOptions
+	opts.ClusterRole = "admin"
+	if _, err := scopedKubeconfig(context.Background(), kc, testLabCluster, &opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if crb := getClusterRoleBinding(t, kc); crb.RoleRef.Name != "admin" {
+		t.Errorf("binding still refers to %s", crb.RoleRef.Name)
+	}
+
+	deleted := false
+	for _, action := range kc.Actions() {
+		if action.Matches("delete", "clusterrolebindings") {
+			deleted = true
+		}
+	}
+	if !deleted {
+		t.Error("binding of the other role was not deleted and recreated")
+	}
+}
+
+func TestScopedKubeconfigTokenTimeout(t *testing.T) {
+	kc := k8sfake.NewSimpleClientset()
+
+	opts := *testScopedOptions
+	opts.TokenTimeout = 50 * time.Millisecond
+	if _, err := scopedKubeconfig(context.Background(), kc, testLabCluster, &opts); err == nil {
+		t.Fatal("expected an error when the token secret is never filled")
+	}
+}
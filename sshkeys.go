@@ -1,16 +1,94 @@
 package utils
 
 import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/pem"
+	"fmt"
+	"github.com/go-playground/validator"
 	"golang.org/x/crypto/ssh"
 	"log"
+	"math/big"
+	"strings"
 )
 
-// GenerateSSHKeys creates SSH Keys for LabRequest
-func GenerateSSHKeys(uuid string) (publickey []byte, privatekey []byte) {
+const (
+	SSHKeyEd25519   SSHKeyAlgorithm = "ed25519"
+	SSHKeyECDSAP256 SSHKeyAlgorithm = "ecdsa-p256"
+	SSHKeyECDSAP384 SSHKeyAlgorithm = "ecdsa-p384"
+	SSHKeyRSA2048   SSHKeyAlgorithm = "rsa-2048"
+	SSHKeyRSA3072   SSHKeyAlgorithm = "rsa-3072"
+	SSHKeyRSA4096   SSHKeyAlgorithm = "rsa-4096"
+)
+
+// GenerateSSHKeyPair creates an SSH key of the given algorithm. comment is stored in the
+// OpenSSH private key and appended to the authorized_keys line of the public key.
+func GenerateSSHKeyPair(algorithm SSHKeyAlgorithm, comment string) (*SSHKeyPair, error) {
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+
+	switch algorithm {
+	case SSHKeyEd25519:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case SSHKeyECDSAP256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case SSHKeyECDSAP384:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case SSHKeyRSA2048:
+		privateKey, err = generatePrivateKey(2048)
+	case SSHKeyRSA3072:
+		privateKey, err = generatePrivateKey(3072)
+	case SSHKeyRSA4096:
+		privateKey, err = generatePrivateKey(4096)
+	default:
+		return nil, fmt.Errorf("unsupported ssh key algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate %s private key: %w", algorithm, err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate public key: %w", err)
+	}
+
+	publicKey := ssh.MarshalAuthorizedKey(sshPublicKey)
+	if comment != "" {
+		publicKey = append(publicKey[:len(publicKey)-1], []byte(" "+comment+"\n")...)
+	}
+
+	privateKeyPEM, err := encodePrivateKeyToPEMBlock(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeyOpenSSH, err := encodePrivateKeyToOpenSSH(privateKey, comment, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SSHKeyPair{
+		Algorithm:         algorithm,
+		PublicKey:         publicKey,
+		PrivateKeyPEM:     privateKeyPEM,
+		PrivateKeyOpenSSH: privateKeyOpenSSH,
+	}, nil
+}
+
+// GenerateSSHKeys creates SSH Keys for LabRequest as a 4096 bit RSA key in PKCS#1 PEM;
+// use GenerateSSHKeyPair for other algorithms and the OpenSSH format
+func GenerateSSHKeys(uuid string) (publickey []byte, privatekey []byte, err error) {
 	// TODO: #1 instead of saving key to local file create OpenShift/K8s secret
 	//PrivateKeyFile := "/tmp/" + uuid
 	//PublicKeyFile := "/tmp/" + uuid + ".pub"
@@ -18,12 +96,12 @@ func GenerateSSHKeys(uuid string) (publickey []byte, privatekey []byte) {
 
 	generatedPrivateKey, err := generatePrivateKey(keyBitSize)
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, nil, fmt.Errorf("unable to generate private key: %w", err)
 	}
 
 	extractedPublicKeyBytes, err := generatePublicKey(&generatedPrivateKey.PublicKey)
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, nil, fmt.Errorf("unable to generate public key: %w", err)
 	}
 
 	generatedPrivateKeyBytes := encodePrivateKeyToPEM(generatedPrivateKey)
@@ -39,7 +117,7 @@ func GenerateSSHKeys(uuid string) (publickey []byte, privatekey []byte) {
 	//	log.Fatal(err.Error())
 	//}
 
-	return extractedPublicKeyBytes, generatedPrivateKeyBytes
+	return extractedPublicKeyBytes, generatedPrivateKeyBytes, nil
 }
 
 // generatePrivateKey creates a RSA Private Key of specified byte size
@@ -56,7 +134,6 @@ func generatePrivateKey(keyBitSize int) (*rsa.PrivateKey, error) {
 		return nil, err
 	}
 
-	log.Println("Private Key generated")
 	return generatedPrivateKey, nil
 }
 
@@ -78,6 +155,161 @@ func encodePrivateKeyToPEM(generatedPrivateKey *rsa.PrivateKey) []byte {
 	return privateKeyPEMFormat
 }
 
+// encodePrivateKeyToPEMBlock encodes a private key in the PEM format customary for its type
+func encodePrivateKeyToPEMBlock(privateKey crypto.Signer) ([]byte, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return encodePrivateKeyToPEM(key), nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal ecdsa private key: %w", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to marshal ed25519 private key: %w", err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+}
+
+// EncryptSSHPrivateKey re-encodes an unencrypted private key, in PEM or OpenSSH format, as an
+// OpenSSH private key encrypted with passphrase (aes256-ctr with a bcrypt_pbkdf derived key).
+func EncryptSSHPrivateKey(privateKey []byte, passphrase []byte, comment string) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+
+	rawKey, err := ssh.ParseRawPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key: %w", err)
+	}
+
+	// the OpenSSH parser returns ed25519 keys by pointer
+	if key, ok := rawKey.(*ed25519.PrivateKey); ok {
+		rawKey = *key
+	}
+
+	signer, ok := rawKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", rawKey)
+	}
+
+	return encodePrivateKeyToOpenSSH(signer, comment, passphrase)
+}
+
+// VerifySSHKeyPassphrase checks that passphrase decrypts the encrypted privateKey.
+func VerifySSHKeyPassphrase(privateKey []byte, passphrase []byte) error {
+	if _, err := ssh.ParsePrivateKeyWithPassphrase(privateKey, passphrase); err != nil {
+		return fmt.Errorf("passphrase does not decrypt private key: %w", err)
+	}
+	return nil
+}
+
+// encodePrivateKeyToOpenSSH encodes a private key in the OpenSSH private key format described in
+// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key, encrypted when a
+// passphrase is given
+func encodePrivateKeyToOpenSSH(privateKey crypto.Signer, comment string, passphrase []byte) ([]byte, error) {
+	sshPublicKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate public key: %w", err)
+	}
+
+	var keyFields []byte
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		keyFields = ssh.Marshal(struct {
+			N    *big.Int
+			E    *big.Int
+			D    *big.Int
+			Iqmp *big.Int
+			P    *big.Int
+			Q    *big.Int
+		}{key.N, big.NewInt(int64(key.E)), key.D, key.Precomputed.Qinv, key.Primes[0], key.Primes[1]})
+	case *ecdsa.PrivateKey:
+		curves := map[elliptic.Curve]string{elliptic.P256(): "nistp256", elliptic.P384(): "nistp384"}
+		curve, ok := curves[key.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported ecdsa curve %s", key.Curve.Params().Name)
+		}
+		keyFields = ssh.Marshal(struct {
+			Curve string
+			Pub   []byte
+			D     *big.Int
+		}{curve, elliptic.Marshal(key.Curve, key.X, key.Y), key.D})
+	case ed25519.PrivateKey:
+		keyFields = ssh.Marshal(struct {
+			Pub  []byte
+			Priv []byte
+		}{key.Public().(ed25519.PublicKey), key})
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+	}
+
+	var check [4]byte
+	if _, err = rand.Read(check[:]); err != nil {
+		return nil, fmt.Errorf("unable to generate check bytes: %w", err)
+	}
+	checkInt := binary.BigEndian.Uint32(check[:])
+
+	privKeyBlock := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		Keytype string
+		Rest    []byte `ssh:"rest"`
+	}{checkInt, checkInt, sshPublicKey.Type(), keyFields})
+	privKeyBlock = append(privKeyBlock, ssh.Marshal(struct{ Comment string }{comment})...)
+
+	cipherName, kdfName, kdfOpts, blockSize := "none", "none", "", 8
+	if len(passphrase) > 0 {
+		cipherName, kdfName, blockSize = "aes256-ctr", "bcrypt", aes.BlockSize
+	}
+
+	// pad the private section to the cipher block size
+	for i := 1; len(privKeyBlock)%blockSize != 0; i++ {
+		privKeyBlock = append(privKeyBlock, byte(i))
+	}
+
+	if len(passphrase) > 0 {
+		salt := make([]byte, 16)
+		if _, err = rand.Read(salt); err != nil {
+			return nil, fmt.Errorf("unable to generate salt: %w", err)
+		}
+		rounds := 16 // ssh-keygen default
+
+		kdfOpts = string(ssh.Marshal(struct {
+			Salt   []byte
+			Rounds uint32
+		}{salt, uint32(rounds)}))
+
+		k, err := bcryptPBKDF(passphrase, salt, rounds, 32+aes.BlockSize)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := aes.NewCipher(k[:32])
+		if err != nil {
+			return nil, err
+		}
+		cipher.NewCTR(c, k[32:]).XORKeyStream(privKeyBlock, privKeyBlock)
+	}
+
+	key := append([]byte("openssh-key-v1\x00"), ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{cipherName, kdfName, kdfOpts, 1, sshPublicKey.Marshal(), privKeyBlock})...)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: key}), nil
+}
+
 // generatePublicKey take a rsa.PublicKey and return bytes suitable for writing to .pub file
 // returns in the format "ssh-rsa ..."
 func generatePublicKey(generatedPrivateKey *rsa.PublicKey) ([]byte, error) {
@@ -103,3 +335,74 @@ func generatePublicKey(generatedPrivateKey *rsa.PublicKey) ([]byte, error) {
 //	log.Printf("Key saved to: %s", saveFileTo)
 //	return nil
 //}
+
+// MinimumRSAKeyBits is the smallest RSA public key accepted by ValidatePublicSSHKeys.
+const MinimumRSAKeyBits = 2048
+
+// ValidatePublicSSHKeys parses one or more public keys in authorized_keys format, one per line,
+// and rejects DSA keys and RSA keys shorter than MinimumRSAKeyBits with ErrWeakSSHKey. Ed25519
+// and ECDSA keys are accepted, also when held by a FIDO security key; other types such as
+// certificates are rejected with ErrUnsupportedSSHKey. Blank lines and lines starting with # are
+// skipped, every other line must be a valid key; key options and comments are dropped from the
+// result.
+func ValidatePublicSSHKeys(keys string) ([]ValidatedSSHKey, error) {
+	var validated []ValidatedSSHKey
+
+	for i, line := range strings.Split(keys, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse public ssh key on line %d: %w", i+1, err)
+		}
+
+		key := ValidatedSSHKey{
+			Type:          publicKey.Type(),
+			Fingerprint:   ssh.FingerprintSHA256(publicKey),
+			AuthorizedKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))),
+		}
+
+		// security keys do not expose their public key as a crypto.PublicKey
+		switch key.Type {
+		case ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256:
+			key.Bits = 256
+			validated = append(validated, key)
+			continue
+		}
+
+		cryptoPublicKey, ok := publicKey.(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s on line %d", ErrUnsupportedSSHKey, key.Type, i+1)
+		}
+
+		switch k := cryptoPublicKey.CryptoPublicKey().(type) {
+		case *rsa.PublicKey:
+			key.Bits = k.N.BitLen()
+			if key.Bits < MinimumRSAKeyBits {
+				return nil, fmt.Errorf("%w: %d bit RSA key %s, at least %d bits required",
+					ErrWeakSSHKey, key.Bits, key.Fingerprint, MinimumRSAKeyBits)
+			}
+		case *dsa.PublicKey:
+			return nil, fmt.Errorf("%w: DSA key %s", ErrWeakSSHKey, key.Fingerprint)
+		case *ecdsa.PublicKey:
+			key.Bits = k.Curve.Params().BitSize
+		case ed25519.PublicKey:
+			key.Bits = 256
+		default:
+			return nil, fmt.Errorf("%w: %s on line %d", ErrUnsupportedSSHKey, key.Type, i+1)
+		}
+
+		validated = append(validated, key)
+	}
+
+	return validated, nil
+}
+
+// validateSSHKeysField is the "sshkeys" validator for LabRequest.PublicSSHKey.
+func validateSSHKeysField(fl validator.FieldLevel) bool {
+	_, err := ValidatePublicSSHKeys(fl.Field().String())
+	return err == nil
+}
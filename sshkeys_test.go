@@ -0,0 +1,240 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+)
+
+func authorizedKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	publicKey, err := ssh.NewPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+}
+
+func TestValidatePublicSSHKeys(t *testing.T) {
+	ed, err := GenerateSSHKeyPair(SSHKeyEd25519, "partner@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsa, err := GenerateSSHKeyPair(SSHKeyECDSAP256, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, ecdsaKey := strings.TrimSpace(string(ed.PublicKey)), strings.TrimSpace(string(ecdsa.PublicKey))
+
+	keys, err := ValidatePublicSSHKeys("# partner keys\n\n" + edKey + "\n  " + ecdsaKey + "  \n# trailing comment\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Type != ssh.KeyAlgoED25519 || keys[1].Bits != 256 {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if strings.Contains(keys[0].AuthorizedKey, "partner@example.com") {
+		t.Errorf("comment kept in %q", keys[0].AuthorizedKey)
+	}
+
+	if keys, err = ValidatePublicSSHKeys(""); err != nil || len(keys) != 0 {
+		t.Errorf("empty input: got %+v, %v", keys, err)
+	}
+
+	if _, err = ValidatePublicSSHKeys("garbage line\n" + edKey); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("garbage line: got %v, want a parse error for line 1", err)
+	}
+	if _, err = ValidatePublicSSHKeys(edKey + "\nssh-ed25519 AAAAtypo"); err == nil {
+		t.Error("mistyped second key accepted")
+	}
+}
+
+func TestValidatePublicSSHKeysWeak(t *testing.T) {
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ValidatePublicSSHKeys(authorizedKey(t, &rsa1024.PublicKey)); !errors.Is(err, ErrWeakSSHKey) {
+		t.Errorf("1024 bit RSA: got %v, want ErrWeakSSHKey", err)
+	}
+
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := ValidatePublicSSHKeys(authorizedKey(t, &rsa2048.PublicKey))
+	if err != nil || len(keys) != 1 || keys[0].Bits != 2048 {
+		t.Errorf("2048 bit RSA: got %+v, %v", keys, err)
+	}
+
+	dsaKey := &dsa.PrivateKey{}
+	if err = dsa.GenerateParameters(&dsaKey.Parameters, rand.Reader, dsa.L1024N160); err != nil {
+		t.Fatal(err)
+	}
+	if err = dsa.GenerateKey(dsaKey, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ValidatePublicSSHKeys(authorizedKey(t, &dsaKey.PublicKey)); !errors.Is(err, ErrWeakSSHKey) {
+		t.Errorf("DSA: got %v, want ErrWeakSSHKey", err)
+	}
+}
+
+var testSSHKeyAlgorithms = []SSHKeyAlgorithm{
+	SSHKeyEd25519, SSHKeyECDSAP256, SSHKeyECDSAP384, SSHKeyRSA2048, SSHKeyRSA3072, SSHKeyRSA4096,
+}
+
+func TestGenerateSSHKeyPair(t *testing.T) {
+	for _, algorithm := range testSSHKeyAlgorithms {
+		algorithm := algorithm
+		t.Run(string(algorithm), func(t *testing.T) {
+			t.Parallel()
+
+			pair, err := GenerateSSHKeyPair(algorithm, "partner@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pair.Algorithm != algorithm {
+				t.Errorf("got algorithm %s", pair.Algorithm)
+			}
+
+			publicKey, comment, _, _, err := ssh.ParseAuthorizedKey(pair.PublicKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if comment != "partner@example.com" {
+				t.Errorf("got comment %q", comment)
+			}
+
+			for format, privateKey := range map[string][]byte{"PEM": pair.PrivateKeyPEM, "OpenSSH": pair.PrivateKeyOpenSSH} {
+				signer, err := ssh.ParsePrivateKey(privateKey)
+				if err != nil {
+					t.Fatalf("%s private key: %v", format, err)
+				}
+				if !bytes.Equal(signer.PublicKey().Marshal(), publicKey.Marshal()) {
+					t.Errorf("%s private key does not match the public key", format)
+				}
+			}
+		})
+	}
+
+	if _, err := GenerateSSHKeyPair("dsa-1024", ""); err == nil {
+		t.Error("unsupported algorithm accepted")
+	}
+}
+
+func TestEncryptSSHPrivateKey(t *testing.T) {
+	for _, algorithm := range testSSHKeyAlgorithms {
+		algorithm := algorithm
+		t.Run(string(algorithm), func(t *testing.T) {
+			t.Parallel()
+
+			pair, err := GenerateSSHKeyPair(algorithm, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for format, privateKey := range map[string][]byte{"PEM": pair.PrivateKeyPEM, "OpenSSH": pair.PrivateKeyOpenSSH} {
+				encrypted, err := EncryptSSHPrivateKey(privateKey, []byte("correct horse"), "partner@example.com")
+				if err != nil {
+					t.Fatalf("%s private key: %v", format, err)
+				}
+				if err = VerifySSHKeyPassphrase(encrypted, []byte("correct horse")); err != nil {
+					t.Errorf("%s private key: %v", format, err)
+				}
+				if err = VerifySSHKeyPassphrase(encrypted, []byte("battery staple")); err == nil {
+					t.Errorf("%s private key decrypted with a wrong passphrase", format)
+				}
+				if _, err = ssh.ParsePrivateKey(encrypted); err == nil {
+					t.Errorf("%s private key readable without a passphrase", format)
+				}
+			}
+		})
+	}
+
+	if _, err := EncryptSSHPrivateKey([]byte("not a key"), []byte("correct horse"), ""); err == nil {
+		t.Error("garbage private key encrypted")
+	}
+}
+
+// securityKey returns the authorized_keys line of a FIDO security key, which cannot be made with
+// ssh.NewPublicKey.
+func securityKey(algorithm string, fields ...interface{}) string {
+	var wire []byte
+	wire = append(wire, ssh.Marshal(struct{ Algorithm string }{algorithm})...)
+	for _, field := range fields {
+		wire = append(wire, ssh.Marshal(field)...)
+	}
+	return algorithm + " " + base64.StdEncoding.EncodeToString(wire) + " partner@yubikey"
+}
+
+func TestValidatePublicSSHKeysSecurityKeys(t *testing.T) {
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := ValidatePublicSSHKeys(strings.Join([]string{
+		securityKey(ssh.KeyAlgoSKED25519, struct {
+			Key         []byte
+			Application string
+		}{edKey, "ssh:"}),
+		securityKey(ssh.KeyAlgoSKECDSA256, struct {
+			Curve       string
+			Key         []byte
+			Application string
+		}{"nistp256", elliptic.Marshal(elliptic.P256(), ecKey.X, ecKey.Y), "ssh:"}),
+	}, "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Type != ssh.KeyAlgoSKED25519 || keys[1].Type != ssh.KeyAlgoSKECDSA256 {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	for _, key := range keys {
+		if key.Bits != 256 || strings.Contains(key.AuthorizedKey, "yubikey") {
+			t.Errorf("unexpected key %+v", key)
+		}
+	}
+}
+
+func TestValidatePublicSSHKeysUnsupported(t *testing.T) {
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := GenerateSSHKeyPair(SSHKeyEd25519, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(pair.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := &ssh.Certificate{Key: publicKey, CertType: ssh.UserCert, ValidBefore: ssh.CertTimeInfinity}
+	if err = cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ValidatePublicSSHKeys(string(ssh.MarshalAuthorizedKey(cert)))
+	if !errors.Is(err, ErrUnsupportedSSHKey) || errors.Is(err, ErrWeakSSHKey) {
+		t.Errorf("certificate: got %v, want ErrUnsupportedSSHKey", err)
+	}
+}
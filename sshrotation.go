@@ -0,0 +1,342 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	hivev1 "github.com/openshift/hive/apis/hive/v1"
+	"golang.org/x/crypto/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+	"strings"
+	"time"
+)
+
+// Entries of the lab secret holding a rotation in progress: the new key pair until the cluster
+// trusts it, then the old public key until the cluster no longer does.
+const (
+	pendingPrivateKeyEntry = "ssh-privatekey-pending"
+	pendingPublicKeyEntry  = "ssh-publickey-pending"
+	retiredPublicKeyEntry  = "ssh-publickey-retired"
+)
+
+var (
+	machineConfigGVR     = schema.GroupVersionResource{Group: "machineconfiguration.openshift.io", Version: "v1", Resource: "machineconfigs"}
+	machineConfigPoolGVR = schema.GroupVersionResource{Group: "machineconfiguration.openshift.io", Version: "v1", Resource: "machineconfigpools"}
+
+	// sshMachineConfigs maps the MachineConfigPools of a lab to the MachineConfig holding
+	// the authorized keys of the core user.
+	sshMachineConfigs = map[string]string{
+		"master": "99-master-ssh",
+		"worker": "99-worker-ssh",
+	}
+)
+
+func (o *SSHKeyRotationOptions) withDefaults() SSHKeyRotationOptions {
+	opts := SSHKeyRotationOptions{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.Algorithm == "" {
+		opts.Algorithm = SSHKeyEd25519
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Minute
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = 15 * time.Second
+	}
+	return opts
+}
+
+// RotateLabSSHKey replaces the SSH key of lab labID. The new key pair is first stored as pending
+// in the per-lab hive secret. Its public key is then added to the 99-master-ssh and 99-worker-ssh
+// MachineConfigs of the lab cluster next to the old one, and once the MachineConfigPools have
+// rolled it out the pending key becomes the lab's key and the old public key is removed from
+// the cluster in a second rollout. A rotation that failed part way is resumed by calling
+// RotateLabSSHKey again.
+func RotateLabSSHKey(ctx context.Context, labID string, opts *SSHKeyRotationOptions) error {
+	dc, err := hiveClient()
+	if err != nil {
+		return err
+	}
+
+	kc, err := K8sAuthenticate()
+	if err != nil {
+		return err
+	}
+
+	cd := hivev1.ClusterDeployment{}
+	if err = dc.Get(ctx, types.NamespacedName{Namespace: "hive", Name: labID}, &cd); err != nil {
+		return fmt.Errorf("unable to get cluster deployment %s: %w", labID, err)
+	}
+
+	if !cd.Spec.Installed || cd.Spec.ClusterMetadata == nil {
+		return fmt.Errorf("%w: %s", ErrLabNotInstalled, labID)
+	}
+
+	kubeconfigSecret, err := kc.CoreV1().Secrets("hive").Get(ctx,
+		cd.Spec.ClusterMetadata.AdminKubeconfigSecretRef.Name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get kubeconfig secret: %w", err)
+	}
+
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigSecret.Data["kubeconfig"])
+	if err != nil {
+		return fmt.Errorf("unable to load admin kubeconfig of lab %s: %w", labID, err)
+	}
+
+	lab, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return fmt.Errorf("unable to create client for lab %s: %w", labID, err)
+	}
+
+	return rotateLabSSHKey(ctx, kc, lab, labID, opts)
+}
+
+func rotateLabSSHKey(ctx context.Context, kc kubernetes.Interface, lab dynamic.Interface, labID string,
+	opts *SSHKeyRotationOptions) error {
+	o := opts.withDefaults()
+
+	labSecret, err := kc.CoreV1().Secrets("hive").Get(ctx, labID, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to get the lab secret: %w", err)
+	}
+
+	// the new key is in place and only the old one is left to revoke
+	if retired := string(labSecret.Data[retiredPublicKeyEntry]); retired != "" {
+		return revokeRetiredSSHKey(ctx, kc, lab, labID, retired, &o)
+	}
+
+	newKey := strings.TrimSpace(string(labSecret.Data[pendingPublicKeyEntry]))
+	if newKey == "" || len(labSecret.Data[pendingPrivateKeyEntry]) == 0 {
+		keyPair, err := GenerateSSHKeyPair(o.Algorithm, "")
+		if err != nil {
+			return err
+		}
+
+		// persist the key before the cluster trusts it, so it is never lost
+		err = updateLabSecret(ctx, kc, labID, func(data map[string][]byte) {
+			data[pendingPrivateKeyEntry] = keyPair.PrivateKeyPEM
+			data[pendingPublicKeyEntry] = keyPair.PublicKey
+		})
+		if err != nil {
+			return fmt.Errorf("unable to store the pending ssh key of lab %s: %w", labID, err)
+		}
+		newKey = strings.TrimSpace(string(keyPair.PublicKey))
+	}
+
+	// phase one: trust both keys so access is never lost mid rollout
+	err = updateAuthorizedKeys(ctx, lab, &o, func(keys []string) []string {
+		for _, key := range keys {
+			if sameAuthorizedKey(key, newKey) {
+				return keys
+			}
+		}
+		return append(keys, newKey)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to add new ssh key to lab %s: %w", labID, err)
+	}
+
+	var oldKey string
+	err = updateLabSecret(ctx, kc, labID, func(data map[string][]byte) {
+		if oldSigner, err := ssh.ParsePrivateKey(data["ssh-privatekey"]); err == nil {
+			oldKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(oldSigner.PublicKey())))
+		}
+
+		data["ssh-privatekey"] = data[pendingPrivateKeyEntry]
+		data["ssh-publickey"] = data[pendingPublicKeyEntry]
+		delete(data, pendingPrivateKeyEntry)
+		delete(data, pendingPublicKeyEntry)
+		if oldKey != "" && !sameAuthorizedKey(oldKey, newKey) {
+			data[retiredPublicKeyEntry] = []byte(oldKey)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update the lab secret: %w", err)
+	}
+
+	if oldKey == "" || sameAuthorizedKey(oldKey, newKey) {
+		return nil
+	}
+
+	return revokeRetiredSSHKey(ctx, kc, lab, labID, oldKey, &o)
+}
+
+// revokeRetiredSSHKey removes the retired public key of a lab from the cluster (phase two) and
+// then forgets it.
+func revokeRetiredSSHKey(ctx context.Context, kc kubernetes.Interface, lab dynamic.Interface, labID, retired string,
+	o *SSHKeyRotationOptions) error {
+	err := updateAuthorizedKeys(ctx, lab, o, func(keys []string) []string {
+		var kept []string
+		for _, key := range keys {
+			if !sameAuthorizedKey(key, retired) {
+				kept = append(kept, key)
+			}
+		}
+		return kept
+	})
+	if err != nil {
+		return fmt.Errorf("unable to revoke old ssh key of lab %s: %w", labID, err)
+	}
+
+	err = updateLabSecret(ctx, kc, labID, func(data map[string][]byte) {
+		delete(data, retiredPublicKeyEntry)
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update the lab secret: %w", err)
+	}
+
+	return nil
+}
+
+// updateLabSecret applies update to the data of the lab secret, retrying on conflicts.
+func updateLabSecret(ctx context.Context, kc kubernetes.Interface, labID string, update func(map[string][]byte)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		labSecret, err := kc.CoreV1().Secrets("hive").Get(ctx, labID, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if labSecret.Data == nil {
+			labSecret.Data = make(map[string][]byte)
+		}
+		update(labSecret.Data)
+
+		_, err = kc.CoreV1().Secrets("hive").Update(ctx, labSecret, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// updateAuthorizedKeys applies update to the authorized keys of the core user in every SSH
+// MachineConfig and waits for the MachineConfigPools to finish rolling out. Pools whose
+// MachineConfig already held the update are still waited on, as an earlier attempt may have
+// stopped before their rollout completed.
+func updateAuthorizedKeys(ctx context.Context, lab dynamic.Interface, o *SSHKeyRotationOptions,
+	update func([]string) []string) error {
+	changedPools := make(map[string]string)
+
+	for pool, name := range sshMachineConfigs {
+		mcp, err := lab.Resource(machineConfigPoolGVR).Get(ctx, pool, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get machine config pool %s: %w", pool, err)
+		}
+		renderedConfig, _, _ := unstructured.NestedString(mcp.Object, "spec", "configuration", "name")
+
+		mc, err := lab.Resource(machineConfigGVR).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get machine config %s: %w", name, err)
+		}
+
+		changed, err := setCoreAuthorizedKeys(mc, update)
+		if err != nil {
+			return fmt.Errorf("machine config %s: %w", name, err)
+		}
+		if !changed {
+			// any rendered configuration will do, as long as the pool settles on it
+			changedPools[pool] = ""
+			continue
+		}
+
+		if _, err = lab.Resource(machineConfigGVR).Update(ctx, mc, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("unable to update machine config %s: %w", name, err)
+		}
+		changedPools[pool] = renderedConfig
+	}
+
+	for pool, previousConfig := range changedPools {
+		if err := waitForPoolRollout(ctx, lab, pool, previousConfig, o); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setCoreAuthorizedKeys rewrites the sshAuthorizedKeys of the core user in mc and reports
+// whether they changed.
+func setCoreAuthorizedKeys(mc *unstructured.Unstructured, update func([]string) []string) (bool, error) {
+	users, found, err := unstructured.NestedSlice(mc.Object, "spec", "config", "passwd", "users")
+	if err != nil || !found {
+		return false, fmt.Errorf("no passwd users found")
+	}
+
+	for i, u := range users {
+		user, ok := u.(map[string]interface{})
+		if !ok || user["name"] != "core" {
+			continue
+		}
+
+		current, _, err := unstructured.NestedStringSlice(user, "sshAuthorizedKeys")
+		if err != nil {
+			return false, err
+		}
+
+		updated := update(append([]string(nil), current...))
+		if len(updated) == 0 {
+			return false, fmt.Errorf("refusing to remove every authorized key of the core user")
+		}
+		if strings.Join(updated, "\n") == strings.Join(current, "\n") {
+			return false, nil
+		}
+
+		keys := make([]interface{}, len(updated))
+		for j, key := range updated {
+			keys[j] = key
+		}
+		user["sshAuthorizedKeys"] = keys
+		users[i] = user
+
+		return true, unstructured.SetNestedSlice(mc.Object, users, "spec", "config", "passwd", "users")
+	}
+
+	return false, fmt.Errorf("no core user found")
+}
+
+// waitForPoolRollout waits until the pool has rendered a configuration other than
+// previousConfig and every machine of the pool runs it.
+func waitForPoolRollout(ctx context.Context, lab dynamic.Interface, pool, previousConfig string,
+	o *SSHKeyRotationOptions) error {
+	err := wait.PollImmediate(o.PollInterval, o.Timeout, func() (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		mcp, err := lab.Resource(machineConfigPoolGVR).Get(ctx, pool, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		specConfig, _, _ := unstructured.NestedString(mcp.Object, "spec", "configuration", "name")
+		statusConfig, _, _ := unstructured.NestedString(mcp.Object, "status", "configuration", "name")
+		if specConfig == previousConfig || specConfig != statusConfig {
+			return false, nil
+		}
+
+		machines, _, _ := unstructured.NestedInt64(mcp.Object, "status", "machineCount")
+		updated, _, _ := unstructured.NestedInt64(mcp.Object, "status", "updatedMachineCount")
+		return machines == updated, nil
+	})
+	if err != nil {
+		return fmt.Errorf("machine config pool %s did not roll out: %w", pool, err)
+	}
+
+	return nil
+}
+
+// sameAuthorizedKey compares two authorized_keys lines ignoring options and comments.
+func sameAuthorizedKey(a, b string) bool {
+	keyA, _, _, _, errA := ssh.ParseAuthorizedKey([]byte(a))
+	keyB, _, _, _, errB := ssh.ParseAuthorizedKey([]byte(b))
+	if errA != nil || errB != nil {
+		return strings.TrimSpace(a) == strings.TrimSpace(b)
+	}
+	return ssh.FingerprintSHA256(keyA) == ssh.FingerprintSHA256(keyB)
+}
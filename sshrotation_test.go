@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testRotationOptions = &SSHKeyRotationOptions{
+	Algorithm:    SSHKeyEd25519,
+	Timeout:      time.Second,
+	PollInterval: time.Millisecond,
+}
+
+// fakeLabCluster is a lab cluster whose MachineConfigPools finish rolling out as soon as
+// a MachineConfig changes.
+type fakeLabCluster struct {
+	*dynamicfake.FakeDynamicClient
+	updates    int
+	failUpdate map[int]bool
+}
+
+func newFakeLabCluster(t *testing.T, authorizedKey string) *fakeLabCluster {
+	t.Helper()
+
+	var objects []runtime.Object
+	for _, name := range sshMachineConfigs {
+		objects = append(objects, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "machineconfiguration.openshift.io/v1",
+			"kind":       "MachineConfig",
+			"metadata":   map[string]interface{}{"name": name},
+			"spec": map[string]interface{}{
+				"config": map[string]interface{}{
+					"passwd": map[string]interface{}{
+						"users": []interface{}{map[string]interface{}{
+							"name":              "core",
+							"sshAuthorizedKeys": []interface{}{authorizedKey},
+						}},
+					},
+				},
+			},
+		}})
+	}
+
+	lab := &fakeLabCluster{
+		FakeDynamicClient: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+		failUpdate:        make(map[int]bool),
+	}
+
+	lab.PrependReactor("update", "machineconfigs", func(k8stesting.Action) (bool, runtime.Object, error) {
+		lab.updates++
+		if lab.failUpdate[lab.updates] {
+			return true, nil, errors.New("machine config update rejected")
+		}
+		return false, nil, nil
+	})
+	lab.PrependReactor("get", "machineconfigpools", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		rendered := fmt.Sprintf("rendered-%d", lab.updates)
+		return true, &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "machineconfiguration.openshift.io/v1",
+			"kind":       "MachineConfigPool",
+			"metadata":   map[string]interface{}{"name": action.(k8stesting.GetAction).GetName()},
+			"spec":       map[string]interface{}{"configuration": map[string]interface{}{"name": rendered}},
+			"status": map[string]interface{}{
+				"configuration":       map[string]interface{}{"name": rendered},
+				"machineCount":        int64(3),
+				"updatedMachineCount": int64(3),
+			},
+		}}, nil
+	})
+
+	return lab
+}
+
+// authorizedKeys returns the authorized keys of the core user of every SSH MachineConfig.
+func (l *fakeLabCluster) authorizedKeys(t *testing.T) map[string][]string {
+	t.Helper()
+
+	keys := make(map[string][]string)
+	for _, name := range sshMachineConfigs {
+		mc, err := l.Resource(machineConfigGVR).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, _, _ := unstructured.NestedSlice(mc.Object, "spec", "config", "passwd", "users")
+		keys[name], _, _ = unstructured.NestedStringSlice(users[0].(map[string]interface{}), "sshAuthorizedKeys")
+	}
+	return keys
+}
+
+func newTestLabSSHSecret(t *testing.T) (kubernetes.Interface, string) {
+	t.Helper()
+
+	keyPair, err := GenerateSSHKeyPair(SSHKeyEd25519, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k8sfake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "hive", Name: testLabID},
+		Data: map[string][]byte{
+			"ssh-privatekey": keyPair.PrivateKeyPEM,
+			"ssh-publickey":  keyPair.PublicKey,
+		},
+	}), strings.TrimSpace(string(keyPair.PublicKey))
+}
+
+func getTestLabSSHSecret(t *testing.T, kc kubernetes.Interface) map[string][]byte {
+	t.Helper()
+
+	labSecret, err := kc.CoreV1().Secrets("hive").Get(context.Background(), testLabID, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return labSecret.Data
+}
+
+// assertRotated checks that the lab secret and every MachineConfig only hold newKey.
+func assertRotated(t *testing.T, kc kubernetes.Interface, lab *fakeLabCluster, newKey string) {
+	t.Helper()
+
+	data := getTestLabSSHSecret(t, kc)
+	if !sameAuthorizedKey(string(data["ssh-publickey"]), newKey) {
+		t.Errorf("lab secret holds %q, want %q", data["ssh-publickey"], newKey)
+	}
+	for _, entry := range []string{pendingPrivateKeyEntry, pendingPublicKeyEntry, retiredPublicKeyEntry} {
+		if _, ok := data[entry]; ok {
+			t.Errorf("lab secret still holds %s", entry)
+		}
+	}
+	for name, keys := range lab.authorizedKeys(t) {
+		if len(keys) != 1 || !sameAuthorizedKey(keys[0], newKey) {
+			t.Errorf("%s trusts %q, want only the new key", name, keys)
+		}
+	}
+}
+
+func TestRotateLabSSHKey(t *testing.T) {
+	ctx := context.Background()
+	kc, oldKey := newTestLabSSHSecret(t)
+	lab := newFakeLabCluster(t, oldKey)
+
+	if err := rotateLabSSHKey(ctx, kc, lab, testLabID, testRotationOptions); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := string(getTestLabSSHSecret(t, kc)["ssh-publickey"])
+	if sameAuthorizedKey(newKey, oldKey) {
+		t.Fatal("ssh key was not replaced")
+	}
+	assertRotated(t, kc, lab, newKey)
+}
+
+func TestRotateLabSSHKeyResume(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("failed adding the new key", func(t *testing.T) {
+		kc, oldKey := newTestLabSSHSecret(t)
+		lab := newFakeLabCluster(t, oldKey)
+		lab.failUpdate[2] = true
+
+		if err := rotateLabSSHKey(ctx, kc, lab, testLabID, testRotationOptions); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		data := getTestLabSSHSecret(t, kc)
+		pendingKey := string(data[pendingPublicKeyEntry])
+		if pendingKey == "" || !sameAuthorizedKey(string(data["ssh-publickey"]), oldKey) {
+			t.Fatalf("pending key not persisted before changing the cluster: %q", data)
+		}
+
+		if err := rotateLabSSHKey(ctx, kc, lab, testLabID, testRotationOptions); err != nil {
+			t.Fatal(err)
+		}
+		assertRotated(t, kc, lab, pendingKey)
+	})
+
+	t.Run("failed revoking the old key", func(t *testing.T) {
+		kc, oldKey := newTestLabSSHSecret(t)
+		lab := newFakeLabCluster(t, oldKey)
+		lab.failUpdate[3] = true
+
+		if err := rotateLabSSHKey(ctx, kc, lab, testLabID, testRotationOptions); err == nil {
+			t.Fatal("expected the rotation to fail")
+		}
+
+		data := getTestLabSSHSecret(t, kc)
+		newKey := string(data["ssh-publickey"])
+		if !sameAuthorizedKey(string(data[retiredPublicKeyEntry]), oldKey) || sameAuthorizedKey(newKey, oldKey) {
+			t.Fatalf("new key not promoted or old key not retired: %q", data)
+		}
+
+		if err := rotateLabSSHKey(ctx, kc, lab, testLabID, testRotationOptions); err != nil {
+			t.Fatal(err)
+		}
+		assertRotated(t, kc, lab, newKey)
+	})
+}
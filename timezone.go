@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
@@ -11,12 +13,30 @@ import (
 	"time"
 )
 
-func GetUtc(url string) time.Time {
+// DefaultHTTPTimeout is the timeout of the HTTP clients the package creates when a caller
+// does not supply one. It is read when a client is created: GetUtc and VaultDelivery read it
+// on every call, a PBClient when NewPBClient creates it.
+var DefaultHTTPTimeout = 30 * time.Second
+
+// TimeAPIClient is the HTTP client used by GetUtc. When nil, GetUtc uses a client with
+// DefaultHTTPTimeout.
+var TimeAPIClient *http.Client
+
+func GetUtc(ctx context.Context, url string) (time.Time, error) {
 	var utctime UtcTime
-	resp, err := http.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to create time request: %w", err)
+	}
+
+	client := TimeAPIClient
+	if client == nil {
+		client = &http.Client{Timeout: DefaultHTTPTimeout}
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
-		log.Printf("Unable to get the time from URL: %v\n", err)
-		return time.Time{}
+		return time.Time{}, fmt.Errorf("unable to get the time from URL: %w", err)
 	}
 
 	defer func(Body io.ReadCloser) {
@@ -26,17 +46,21 @@ func GetUtc(url string) time.Time {
 		}
 	}(resp.Body)
 
-	data, _ := ioutil.ReadAll(resp.Body)
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unable to read utc time api response: %w", err)
+	}
+
 	err = json.Unmarshal(data, &utctime)
 	if err != nil {
-		log.Printf("Unable to unmarshal utc time api response into UtcTime struct: %v", err)
+		return time.Time{}, fmt.Errorf("unable to unmarshal utc time api response into UtcTime struct: %w", err)
 	}
 
 	utc, err := time.Parse("2006-01-02 15:04:05", utctime.Formatted)
 	if err != nil {
-		log.Printf("Unable to parse provided UTC string: %v\n", err)
+		return time.Time{}, fmt.Errorf("unable to parse provided UTC string: %w", err)
 	}
-	return utc
+	return utc, nil
 }
 
 func SetHoursAsInts(utc time.Time) []int {
@@ -62,5 +86,5 @@ func SetHoursAsInts(utc time.Time) []int {
 }
 
 // example usage
-// utc := GetUtc("redacted")
+// utc, err := GetUtc(ctx, "redacted")
 // hoursAsInts := SetHoursAsInts(utc)
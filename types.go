@@ -1,9 +1,17 @@
 package utils
 
 import (
+	"context"
+	"encoding/json"
 	"github.com/google/uuid"
+	"io"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"net"
+	"net/http"
 	"net/url"
-  "time"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"time"
 )
 
 // RequestForm is used by pop to map your request_forms database table to your go code.
@@ -40,8 +48,28 @@ type RequestForm struct {
 	State                string    `json:"state" db:"state"`
 	CreatedAt            time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt            time.Time `json:"updated_at" db:"updated_at"`
+
+	// Transitions is the history of lifecycle changes applied through Transition, stored
+	// as JSON in the transitions column.
+	Transitions StateTransitions `json:"transitions,omitempty" db:"transitions"`
+}
+
+// LabState is a step in the lifecycle of a lab request, stored in RequestForm.State.
+type LabState string
+
+// StateTransition records a single move of a lab request between two states.
+type StateTransition struct {
+	From LabState  `json:"from"`
+	To   LabState  `json:"to"`
+	At   time.Time `json:"at"`
 }
 
+// StateTransitions is the transition history of a lab request. It is stored as a JSON array.
+type StateTransitions []StateTransition
+
+// StateHook is called after a RequestForm has moved to a new state.
+type StateHook func(ctx context.Context, form *RequestForm, transition StateTransition) error
+
 type LabRequest struct {
 	Timestamp                    string    `json:"time"`
 	Epoch                        int       `json:"epoch" validate:"required"`
@@ -62,8 +90,9 @@ type LabRequest struct {
 	CertificationProject         string    `json:"certificationProject" validate:"omitempty"`
 	IntendedCertificationProject string    `json:"intendedCertificationProject" validate:"omitempty"`
 	ProjectName                  string    `json:"projectName" validate:"omitempty"`
-	PublicSSHKey                 string    `json:"publicsshkey" validate:"omitempty"`
+	PublicSSHKey                 string    `json:"publicsshkey" validate:"omitempty,sshkeys"`
 	ClusterName                  string    `json:"clusterName" validate:"required"`
+	ShortID                      string    `json:"shortid" validate:"omitempty"`
 	ClusterSize                  int       `json:"clusterSize" validate:"omitempty"`
 	OpenShiftVersion             string    `json:"openShiftVersion" validate:"required"`
 	Description                  string    `json:"description" validate:"omitempty"`
@@ -100,7 +129,41 @@ type InstallConfig struct {
 	RegionDesignation string
 	Region            string
 	PullSecret        string
-	PublicSSHKey      string
+
+	// PublicSSHKey is PublicSSHKeys as a YAML scalar that templates can render as is, e.g.
+	// "sshKey: {{.PublicSSHKey}}". A single key is the plain key, as it always was; several
+	// keys are one double-quoted scalar with a key per line.
+	PublicSSHKey  string
+	PublicSSHKeys []string
+}
+
+// SSHKeyAlgorithm selects the type and size of keys made by GenerateSSHKeyPair.
+type SSHKeyAlgorithm string
+
+// SSHKeyPair is a generated SSH key. The private key is provided both as a PEM block (PKCS#1
+// for RSA, SEC 1 for ECDSA, PKCS#8 for Ed25519) and in the OpenSSH private key format.
+type SSHKeyPair struct {
+	Algorithm         SSHKeyAlgorithm
+	PublicKey         []byte
+	PrivateKeyPEM     []byte
+	PrivateKeyOpenSSH []byte
+}
+
+// SSHKeyRotationOptions control RotateLabSSHKey. Empty fields fall back to an Ed25519 key,
+// a 30 minute rollout timeout per phase and a 15 second poll interval.
+type SSHKeyRotationOptions struct {
+	Algorithm    SSHKeyAlgorithm
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+// ValidatedSSHKey is a public key accepted by ValidatePublicSSHKeys. AuthorizedKey is the key
+// without options or comment.
+type ValidatedSSHKey struct {
+	Type          string
+	Bits          int
+	Fingerprint   string
+	AuthorizedKey string
 }
 
 type Alphabet struct {
@@ -117,12 +180,29 @@ type Cfg struct {
 	OpenDiscussion   bool   `json:"open_discussion"`
 	BurnAfterReading bool   `json:"burn_after_reading"`
 	Formatter        string `json:"formatter"`
+	Compression      string `json:"compression"`
+}
+
+// PBConfigFile is the layout of the PrivateBin configuration file: a set of named profiles
+// and the profile used when none is selected.
+type PBConfigFile struct {
+	Default  string `json:"default"`
+	Profiles []Cfg  `json:"profiles"`
 }
 
 type PBClient struct {
-	URL      url.URL
-	Username string
-	Password string
+	URL        url.URL
+	Username   string
+	Password   string
+	HTTPClient *http.Client
+
+	// Compression applied to paste contents before encryption, "none" or "zlib".
+	// An empty value means "none".
+	Compression string
+
+	// Rand is the source of paste keys, IVs and salts. It defaults to crypto/rand and
+	// should only be replaced to produce deterministic test vectors.
+	Rand io.Reader
 }
 
 type CreatePasteRequest struct {
@@ -144,6 +224,45 @@ type CreatePasteResponse struct {
 	DeleteToken string `json:"deletetoken"`
 }
 
+type GetPasteResponse struct {
+	ID      string               `json:"id"`
+	Status  int                  `json:"status"`
+	Message string               `json:"message"`
+	URL     string               `json:"url"`
+	V       int                  `json:"v"`
+	AData   json.RawMessage      `json:"adata"`
+	Meta    GetPasteResponseMeta `json:"meta"`
+	CT      string               `json:"ct"`
+}
+
+type GetPasteResponseMeta struct {
+	Created    int64 `json:"created"`
+	TimeToLive int64 `json:"time_to_live"`
+}
+
+type DeletePasteRequest struct {
+	PasteID     string `json:"pasteid"`
+	DeleteToken string `json:"deletetoken"`
+}
+
+// LabPaste is a credential paste created for a lab, kept so the paste can be revoked later.
+type LabPaste struct {
+	ID          string    `json:"id"`
+	DeleteToken string    `json:"deletetoken"`
+	Expire      string    `json:"expire"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// LabPasteResult describes the credential pastes issued for a single lab. Expires is zero for
+// pastes that never expire.
+type LabPasteResult struct {
+	LabID         string
+	PasswordURL   string
+	KubeconfigURL string
+	Expires       time.Time
+	Pastes        []LabPaste
+}
+
 type PasteSpec struct {
 	IV          string
 	Salt        string
@@ -163,8 +282,80 @@ type PasteData struct {
 	BurnAfterReading bool
 }
 
+// PasteContent is the plain text of a paste. Attachments are data URIs with the file name at
+// the same index in AttachmentName; a single attachment is encoded as plain strings, which
+// every PrivateBin version understands, several as arrays, which needs PrivateBin 1.7 or later.
 type PasteContent struct {
-	Paste string `json:"paste"`
+	Paste          string   `json:"paste"`
+	Attachment     []string `json:"attachment,omitempty"`
+	AttachmentName []string `json:"attachment_name,omitempty"`
+}
+
+// PasteAttachment is a decoded file attached to a paste.
+type PasteAttachment struct {
+	Name      string
+	MediaType string
+	Data      []byte
+}
+
+// LabCredentials are the secrets handed to a partner once their lab is installed.
+type LabCredentials struct {
+	LabID         string
+	ClusterName   string
+	ConsoleURL    string
+	AdminPassword string
+	Kubeconfig    []byte
+	SSHPrivateKey []byte
+}
+
+// ScopedKubeconfigOptions describe the ServiceAccount minted on a lab cluster for a scoped
+// partner kubeconfig. Empty fields fall back to the package defaults.
+type ScopedKubeconfigOptions struct {
+	Namespace      string
+	ServiceAccount string
+	ClusterRole    string
+	TokenTimeout   time.Duration
+	PollInterval   time.Duration
+}
+
+// CredentialDelivery hands the credentials of a lab to the partner and returns where they
+// can be picked up.
+type CredentialDelivery interface {
+	Deliver(ctx context.Context, creds *LabCredentials) ([]AccessReference, error)
+}
+
+// AccessReference tells a partner where a delivered credential can be retrieved.
+type AccessReference struct {
+	Backend  string `json:"backend"`
+	Name     string `json:"name"`
+	Location string `json:"location"`
+}
+
+// PrivateBinDelivery delivers the admin password and kubeconfig as two PrivateBin pastes.
+type PrivateBinDelivery struct {
+	Client *PBClient
+	Config *Cfg
+
+	// Password, when set, is required in addition to the paste URLs.
+	Password string
+
+	// RecordPastes stores the delete tokens of the pastes on the lab's ClusterDeployment.
+	RecordPastes bool
+}
+
+// VaultDelivery writes the credentials to a Vault KV version 2 secrets engine.
+type VaultDelivery struct {
+	Address    string
+	Token      string
+	Mount      string
+	PathPrefix string
+	HTTPClient *http.Client
+}
+
+// SecretDelivery stores the credentials in a secret in the partner's namespace.
+type SecretDelivery struct {
+	Client    kubernetes.Interface
+	Namespace string
 }
 
 type FormRequest struct {
@@ -177,3 +368,132 @@ type UtcTime struct {
 	Message   string `json:"message"`
 	Formatted string `json:"formatted"`
 }
+
+// LabReport holds the lab details that are filled into a Google Doc report template.
+type LabReport struct {
+	CompanyName string
+	ClusterName string
+	ConsoleURL  string
+	LeaseStart  time.Time
+	LeaseEnd    time.Time
+	Sponsor     string
+	Contacts    []string
+}
+
+// DNSZone looks up the records the installer creates for a cluster in a base domain.
+type DNSZone interface {
+	ClusterRecords(ctx context.Context, clusterName, baseDomain string) ([]string, error)
+}
+
+// StaticDNSZone is a DNSZone backed by a fixed list of fully qualified record names.
+type StaticDNSZone struct {
+	Records []string
+}
+
+// ResolverDNSZone is a DNSZone that resolves the api and apps records of a cluster.
+type ResolverDNSZone struct {
+	Resolver *net.Resolver
+}
+
+// ClusterNameCheck is the outcome of checking a lab's cluster name against the hub and DNS.
+type ClusterNameCheck struct {
+	ClusterName string   `json:"clusterName"`
+	Available   bool     `json:"available"`
+	Conflicts   []string `json:"conflicts,omitempty"`
+	Alternative string   `json:"alternative,omitempty"`
+	ShortID     string   `json:"shortid,omitempty"`
+}
+
+// ClusterSize describes the machines of one of the lab cluster sizes partners can request.
+type ClusterSize struct {
+	MasterType     string `json:"masterType"`
+	WorkerType     string `json:"workerType"`
+	MasterReplicas int    `json:"masterReplicas"`
+	WorkerReplicas int    `json:"workerReplicas"`
+}
+
+// CapacityLimit caps the labs of a provider region. Zero values mean no limit.
+type CapacityLimit struct {
+	MaxVCPU int `json:"maxVCPU"`
+	MaxLabs int `json:"maxLabs"`
+}
+
+// CapacityUsage is the vCPU and lab count used by active labs in a provider region. Warnings
+// name the labs that were counted with a fallback size or instance type.
+type CapacityUsage struct {
+	Provider string        `json:"provider"`
+	Region   string        `json:"region"`
+	Labs     int           `json:"labs"`
+	VCPU     int           `json:"vcpu"`
+	Limit    CapacityLimit `json:"limit"`
+	Warnings []string      `json:"warnings,omitempty"`
+}
+
+// CapacityDecision tells whether a new lab can be provisioned now.
+type CapacityDecision string
+
+// CapacityPlan is the outcome of planning a new lab against the capacity of its region.
+type CapacityPlan struct {
+	Decision      CapacityDecision `json:"decision"`
+	Reason        string           `json:"reason"`
+	RequestedVCPU int              `json:"requestedVCPU"`
+	Usage         CapacityUsage    `json:"usage"`
+}
+
+// QueuedLabState is the state of a lab in the provisioning queue.
+type QueuedLabState string
+
+// QueuedLabRequest is the part of a LabRequest needed to provision it. The queue keeps only
+// these fields so that no partner contact details end up in its ConfigMap.
+type QueuedLabRequest struct {
+	ID               uuid.UUID `json:"labid"`
+	ClusterName      string    `json:"clusterName"`
+	ShortID          string    `json:"shortid,omitempty"`
+	ClusterSize      int       `json:"clusterSize"`
+	LeaseTime        int       `json:"leaseTime"`
+	Availability     string    `json:"availability"`
+	PublicSSHKey     string    `json:"publicsshkey,omitempty"`
+	OpenShiftVersion string    `json:"openShiftVersion"`
+}
+
+// QueuedLab is an approved LabRequest waiting in the provisioning queue.
+type QueuedLab struct {
+	Request     QueuedLabRequest `json:"request"`
+	Provider    string           `json:"provider"`
+	Region      string           `json:"region"`
+	State       QueuedLabState   `json:"state"`
+	EnqueuedAt  time.Time        `json:"enqueuedAt"`
+	Attempts    int              `json:"attempts"`
+	NextAttempt time.Time        `json:"nextAttempt,omitempty"`
+	LastError   string           `json:"lastError,omitempty"`
+
+	// Waiting tells why a pending lab is waiting for capacity in its region.
+	Waiting string `json:"waiting,omitempty"`
+}
+
+// ProvisioningQueue provisions queued labs from a ConfigMap on the hub, at most Concurrency
+// provisioning or installing at a time per provider region, retrying transient failures with
+// Backoff.
+type ProvisioningQueue struct {
+	Client      kubernetes.Interface
+	Hive        client.Client
+	Namespace   string
+	Name        string
+	Concurrency int
+	MaxAttempts int
+	Backoff     wait.Backoff
+
+	// PollInterval is how often Run looks for labs that are ready to provision.
+	PollInterval time.Duration
+
+	// Provision creates the lab; CreateClusterDeployment by default. It is given a LabRequest
+	// holding only the fields of QueuedLabRequest.
+	Provision func(ctx context.Context, labRequest *LabRequest) error
+
+	// IsTransient reports whether a failed attempt should be retried.
+	IsTransient func(err error) bool
+
+	// Limits are the capacity limits labs are planned against with PlanLab before they start.
+	// Without limits labs are only held back by Concurrency.
+	Limits map[string]CapacityLimit
+}